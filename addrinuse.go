@@ -0,0 +1,13 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isAddrInUse reports whether the error is caused by the address already in use.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
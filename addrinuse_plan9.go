@@ -0,0 +1,9 @@
+//go:build plan9
+
+package main
+
+// isAddrInUse reports whether the error is caused by the address already in use.
+// Plan 9 has no errno telling it, so listening is never retried there.
+func isAddrInUse(err error) bool {
+	return false
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// wsaeaddrinuse is WSAEADDRINUSE, which Winsock returns instead of syscall.EADDRINUSE.
+const wsaeaddrinuse syscall.Errno = 10048
+
+// isAddrInUse reports whether the error is caused by the address already in use.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, wsaeaddrinuse) || errors.Is(err, syscall.EADDRINUSE)
+}
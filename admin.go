@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+// adminTemplate is the page served to the admin path.
+var adminTemplate = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mock-server</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: right; }
+tr.served { color: #999; }
+tr.next { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>mock-server</h1>
+<p>Requests: <span id="requests">{{.Requests}}</span>, served: <span id="served">{{.Served}}</span> of {{len .Responses}}{{if .Paused}}, paused{{end}}</p>
+{{- if .ControlPath}}
+<p>
+<button onclick="control('reset')">Reset</button>
+<button onclick="control('skip')">Skip</button>
+{{- if .Paused}}
+<button onclick="control('resume')">Resume</button>
+{{- else}}
+<button onclick="control('pause')">Pause</button>
+{{- end}}
+</p>
+<script>
+function control(action) {
+  fetch({{.ControlPath}}, {method: "POST", headers: {"Content-Type": "application/json"}, body: JSON.stringify({action: action})})
+    .then(function () { location.reload(); });
+}
+</script>
+{{- end}}
+<table>
+<tr><th>Index</th><th>Status</th><th>Bytes</th><th>State</th></tr>
+{{- range .Responses}}
+<tr class="{{.State}}"><td>{{.Index}}</td><td>{{.Status}}</td><td>{{.Bytes}}</td><td>{{.State}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// adminPage is the data of adminTemplate.
+type adminPage struct {
+	Requests int
+	// Served is the number of responses served in the sequence.
+	Served      int
+	Paused      bool
+	ControlPath string
+	Responses   []adminResponse
+}
+
+type adminResponse struct {
+	Index  int
+	Status int
+	Bytes  int
+	// State is "served", "next" or "pending".
+	State string
+}
+
+// serveAdmin serves the page of the sequence with buttons for the control path if any.
+// Requests to it are not counted as requests nor logged.
+func (h *handler) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.Lock()
+	page := adminPage{
+		Requests:    h.requestCount,
+		Paused:      h.resumeCh != nil,
+		ControlPath: h.controlPath,
+	}
+	next := -1
+	for i, resp := range h.responses {
+		state := "pending"
+		if i < h.pos || h.servedAhead[i] {
+			state = "served"
+			page.Served++
+		} else if next < 0 {
+			state, next = "next", i
+		}
+		page.Responses = append(page.Responses, adminResponse{i, resp.statusCode, len(resp.body), state})
+	}
+	h.mu.Unlock()
+
+	b := &bytes.Buffer{}
+	if err := adminTemplate.Execute(b, page); err != nil {
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Failed to render admin page: %v", err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler_ServeAdmin(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("first")},
+			{statusCode: 404, body: []byte("second!")},
+			{statusCode: 500, body: []byte("third")},
+		},
+		shutdownServer: func() {},
+		controlPath:    "/_control",
+		adminPath:      "/_admin",
+	}
+	handler.logger.out = io.Discard
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/_admin", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin request failed: %d %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type: expect text/html, got: %s", ct)
+	}
+	body := w.Body.String()
+	for _, expect := range []string{
+		`Requests: <span id="requests">1</span>, served: <span id="served">1</span> of 3`,
+		`<tr class="served"><td>0</td><td>200</td><td>5</td><td>served</td></tr>`,
+		`<tr class="next"><td>1</td><td>404</td><td>7</td><td>next</td></tr>`,
+		`<tr class="pending"><td>2</td><td>500</td><td>5</td><td>pending</td></tr>`,
+		`control('skip')`,
+		`fetch("/_control"`,
+	} {
+		if !strings.Contains(body, expect) {
+			t.Errorf("page does not contain %q:\n%s", expect, body)
+		}
+	}
+
+	if handler.requestCount != 1 {
+		t.Errorf("admin requests should not be counted, but requestCount is %d", handler.requestCount)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/_admin", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status: expect %d, got: %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// asyncStatusPath is the path prefix of the status URLs of requests served asynchronously.
+const asyncStatusPath = "/_async/"
+
+// asyncResult is the response fetched from a status URL.
+type asyncResult struct {
+	resp *response
+	// isLast is whether the sequence is over and no other result is held,
+	// in which case the server shuts down.
+	isLast bool
+}
+
+// prefersAsync reports whether the request has Prefer: respond-async.
+func prefersAsync(r *http.Request) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			token, _, _ := strings.Cut(pref, ";")
+			if strings.EqualFold(strings.TrimSpace(token), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// holdAsync holds the response and returns its status URL.
+// isLast is whether the response is the last of the sequence.
+func (h *handler) holdAsync(resp *response, isLast bool) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.asyncCount++
+	id := strconv.Itoa(h.asyncCount)
+	if h.asyncResults == nil {
+		h.asyncResults = map[string]*response{}
+	}
+	h.asyncResults[id] = resp
+	h.asyncDone = h.asyncDone || isLast
+	return asyncStatusPath + id
+}
+
+// asyncResultFor returns the result held for the status URL of the request and forgets it.
+// It returns nil, false if the request is not to a status URL, or nil, true if no result is held for it.
+func (h *handler) asyncResultFor(r *http.Request) (*asyncResult, bool) {
+	if !h.preferAsync {
+		return nil, false
+	}
+	id, ok := strings.CutPrefix(r.URL.Path, asyncStatusPath)
+	if !ok {
+		return nil, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	resp, ok := h.asyncResults[id]
+	if !ok {
+		return nil, true
+	}
+	delete(h.asyncResults, id)
+	return &asyncResult{resp: resp, isLast: h.asyncDone && len(h.asyncResults) == 0}, true
+}
+
+// serveAccepted responds 202 pointing to the status URL.
+func serveAccepted(w http.ResponseWriter, location string) {
+	w.Header().Set("Location", location)
+	w.Header().Set("Preference-Applied", "respond-async")
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+// serverErrorHeaders are the headers net/http writes with the responses to requests it cannot parse.
+// The responses of the handler always have a Date header, so these never follow their status line.
+const serverErrorHeaders = "\r\nContent-Type: text/plain; charset=utf-8\r\nConnection: close\r\n\r\n"
+
+// badRequestListener replaces the body of the 400 responses net/http writes to malformed requests
+// before any handler runs. It works on plain HTTP/1.x connections only since the responses over
+// TLS are encrypted before they reach the connection, and HTTP/2 reports malformed requests
+// with stream errors instead.
+type badRequestListener struct {
+	net.Listener
+	body []byte
+}
+
+func (l *badRequestListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &badRequestConn{Conn: conn, body: l.body}, nil
+}
+
+type badRequestConn struct {
+	net.Conn
+	body []byte
+}
+
+// Write rewrites the write of a 400 response of net/http, which is written in a single write
+// just before the connection is closed.
+func (c *badRequestConn) Write(b []byte) (int, error) {
+	if !bytes.HasPrefix(b, []byte("HTTP/1.1 400 ")) {
+		return c.Conn.Write(b)
+	}
+	statusLine, rest, ok := bytes.Cut(b, []byte("\r\n"))
+	if !ok || !bytes.HasPrefix(rest, []byte(serverErrorHeaders[2:])) {
+		return c.Conn.Write(b)
+	}
+	resp := &bytes.Buffer{}
+	fmt.Fprintf(resp, "%s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n", statusLine, len(c.body))
+	resp.Write(c.body)
+	if _, err := c.Conn.Write(resp.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
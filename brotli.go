@@ -0,0 +1,282 @@
+package main
+
+import "sort"
+
+// brotliBlockSize is the maximum length of a meta-block written by brotliBody.
+const brotliBlockSize = 1 << 16
+
+// brotliMaxCodeLength is the maximum length of a literal code in brotli.
+const brotliMaxCodeLength = 15
+
+// brotliCodeLengthOrder is the order in which the lengths of the code length code are written.
+var brotliCodeLengthOrder = [...]int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// brotliCodeLengthCode is the static code (value, bits) for the lengths of the code length code.
+var brotliCodeLengthCode = [...][2]uint64{{0, 2}, {7, 4}, {3, 3}, {2, 2}, {1, 2}, {15, 4}}
+
+// brotliInsertBase and brotliInsertExtra are the base values and the numbers of extra bits of the insert length codes.
+var brotliInsertBase = [...]int{0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26, 34, 50, 66, 98, 130, 194, 322, 578, 1090, 2114, 6210, 22594}
+var brotliInsertExtra = [...]uint{0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 12, 14, 24}
+
+// brotliBody encodes the body in the brotli format (RFC 7932).
+// Each meta-block is a single insert command whose literals are Huffman-coded;
+// backward references are not used. A meta-block that does not get smaller is stored uncompressed.
+func brotliBody(body []byte) []byte {
+	w := &bitWriter{}
+	// WBITS = 16
+	w.writeBits(0, 1)
+	for len(body) > 0 {
+		n := min(len(body), brotliBlockSize)
+		// The compressed meta-block is written separately, continuing from the last partial byte.
+		compressed := &bitWriter{used: w.used}
+		if w.used > 0 {
+			compressed.buf = []byte{w.buf[len(w.buf)-1]}
+		}
+		writeBrotliCompressed(compressed, body[:n])
+		if len(compressed.buf) < n {
+			if w.used > 0 {
+				w.buf = w.buf[:len(w.buf)-1]
+			}
+			w.buf = append(w.buf, compressed.buf...)
+			w.used = compressed.used
+		} else {
+			writeBrotliStored(w, body[:n])
+		}
+		body = body[n:]
+	}
+	// ISLAST = 1, ISLASTEMPTY = 1
+	w.writeBits(1, 1)
+	w.writeBits(1, 1)
+	w.align()
+	return w.buf
+}
+
+// writeBrotliHeader writes the meta-block header up to ISUNCOMPRESSED.
+func writeBrotliHeader(w *bitWriter, n int, uncompressed bool) {
+	nibbles := uint(4)
+	for n-1 >= 1<<(nibbles*4) {
+		nibbles++
+	}
+	// ISLAST = 0, MNIBBLES, MLEN - 1, ISUNCOMPRESSED
+	w.writeBits(0, 1)
+	w.writeBits(uint64(nibbles-4), 2)
+	w.writeBits(uint64(n-1), nibbles*4)
+	if uncompressed {
+		w.writeBits(1, 1)
+	} else {
+		w.writeBits(0, 1)
+	}
+}
+
+func writeBrotliStored(w *bitWriter, data []byte) {
+	writeBrotliHeader(w, len(data), true)
+	w.align()
+	w.buf = append(w.buf, data...)
+}
+
+func writeBrotliCompressed(w *bitWriter, data []byte) {
+	writeBrotliHeader(w, len(data), false)
+	// NBLTYPESL = NBLTYPESI = NBLTYPESD = 1
+	w.writeBits(0, 3)
+	// NPOSTFIX = 0, NDIRECT = 0
+	w.writeBits(0, 6)
+	// context mode of the literals: LSB6
+	w.writeBits(0, 2)
+	// NTREESL = NTREESD = 1
+	w.writeBits(0, 2)
+
+	freqs := make([]int, 256)
+	distinct := 0
+	for _, b := range data {
+		if freqs[b] == 0 {
+			distinct++
+		}
+		freqs[b]++
+	}
+	lengths := huffmanLengths(freqs, brotliMaxCodeLength)
+	codes := canonicalCodes(lengths)
+	writeBrotliPrefixCode(w, lengths, 8)
+	if distinct == 1 {
+		// A single literal takes no bits.
+		lengths = make([]uint8, len(lengths))
+	}
+
+	insert := 0
+	for insert+1 < len(brotliInsertBase) && brotliInsertBase[insert+1] <= len(data) {
+		insert++
+	}
+	// The only insert-and-copy command uses the copy length code 0.
+	command := 128
+	if insert >= 16 {
+		command = 448
+	} else if insert >= 8 {
+		command = 256
+	}
+	command += insert & 7 << 3
+	writeBrotliSimpleCode(w, command, 10)
+	// distance code, which is never read
+	writeBrotliSimpleCode(w, 0, 6)
+
+	// The command symbol takes no bits since its code has a single symbol.
+	w.writeBits(uint64(len(data)-brotliInsertBase[insert]), brotliInsertExtra[insert])
+	for _, b := range data {
+		w.writeBits(codes[b], uint(lengths[b]))
+	}
+	// The meta-block ends after the literals, so the copy length and distance are omitted.
+}
+
+// writeBrotliSimpleCode writes a simple prefix code with a single symbol.
+func writeBrotliSimpleCode(w *bitWriter, symbol int, alphabetBits uint) {
+	// HSKIP = 1, NSYM - 1 = 0
+	w.writeBits(1, 2)
+	w.writeBits(0, 2)
+	w.writeBits(uint64(symbol), alphabetBits)
+}
+
+// writeBrotliPrefixCode writes the code with the lengths, which must be a complete code unless it has a single symbol.
+func writeBrotliPrefixCode(w *bitWriter, lengths []uint8, alphabetBits uint) {
+	last := -1
+	used := 0
+	for s, l := range lengths {
+		if l > 0 {
+			last = s
+			used++
+		}
+	}
+	if used <= 1 {
+		writeBrotliSimpleCode(w, max(last, 0), alphabetBits)
+		return
+	}
+
+	// The code lengths are written up to the last symbol, where the code becomes complete.
+	freqs := make([]int, len(brotliCodeLengthOrder))
+	for _, l := range lengths[:last+1] {
+		freqs[l]++
+	}
+	clLengths := huffmanLengths(freqs, 5)
+	clCodes := canonicalCodes(clLengths)
+	clUsed := 0
+	for _, l := range clLengths {
+		if l > 0 {
+			clUsed++
+		}
+	}
+	// HSKIP = 0
+	w.writeBits(0, 2)
+	space := 32
+	for _, s := range brotliCodeLengthOrder {
+		l := clLengths[s]
+		w.writeBits(brotliCodeLengthCode[l][0], uint(brotliCodeLengthCode[l][1]))
+		if l > 0 {
+			space -= 32 >> l
+			if space <= 0 {
+				break
+			}
+		}
+	}
+	for _, l := range lengths[:last+1] {
+		// A single code length symbol takes no bits.
+		if clUsed > 1 {
+			w.writeBits(clCodes[l], uint(clLengths[l]))
+		}
+	}
+}
+
+// huffmanLengths returns the code lengths of a Huffman code for the frequencies, limited to maxLength.
+// The symbols whose frequency is zero get no code. A single symbol gets the length 1.
+func huffmanLengths(freqs []int, maxLength uint8) []uint8 {
+	weights := append([]int{}, freqs...)
+	for {
+		lengths := huffmanLengthsUnlimited(weights)
+		longest := uint8(0)
+		for _, l := range lengths {
+			longest = max(longest, l)
+		}
+		if longest <= maxLength {
+			return lengths
+		}
+		// Flatten the distribution until the tree becomes shallow enough.
+		for s, f := range weights {
+			if f > 0 {
+				weights[s] = max(f/2, 1)
+			}
+		}
+	}
+}
+
+func huffmanLengthsUnlimited(freqs []int) []uint8 {
+	type node struct {
+		weight  int
+		symbols []int
+	}
+	nodes := []node{}
+	for s, f := range freqs {
+		if f > 0 {
+			nodes = append(nodes, node{weight: f, symbols: []int{s}})
+		}
+	}
+	lengths := make([]uint8, len(freqs))
+	if len(nodes) == 1 {
+		lengths[nodes[0].symbols[0]] = 1
+	}
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+		merged := node{weight: nodes[0].weight + nodes[1].weight}
+		merged.symbols = append(append(merged.symbols, nodes[0].symbols...), nodes[1].symbols...)
+		for _, s := range merged.symbols {
+			lengths[s]++
+		}
+		nodes = append(nodes[2:], merged)
+	}
+	return lengths
+}
+
+// canonicalCodes returns the canonical codes for the lengths, bit-reversed to be written from the least significant bit.
+func canonicalCodes(lengths []uint8) []uint64 {
+	counts := make([]int, 17)
+	for _, l := range lengths {
+		if l > 0 {
+			counts[l]++
+		}
+	}
+	next := make([]uint64, 17)
+	code := uint64(0)
+	for l := 1; l < len(next); l++ {
+		code = (code + uint64(counts[l-1])) << 1
+		next[l] = code
+	}
+	codes := make([]uint64, len(lengths))
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		for i := uint8(0); i < l; i++ {
+			codes[s] |= (c >> i & 1) << (l - 1 - i)
+		}
+	}
+	return codes
+}
+
+// bitWriter writes bits from the least significant one as brotli does.
+type bitWriter struct {
+	buf []byte
+	// used is the number of bits used in the last byte of buf. Zero means it is full.
+	used uint
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for i := uint(0); i < n; i++ {
+		if w.used == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte(v>>i&1) << w.used
+		w.used = (w.used + 1) % 8
+	}
+}
+
+// align pads the last byte with zero bits.
+func (w *bitWriter) align() {
+	w.used = 0
+}
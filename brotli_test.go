@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// decodeBrotli decodes the stream with the port of the reference brotli decoder.
+func decodeBrotli(t *testing.T, b []byte) []byte {
+	t.Helper()
+	decoded, err := io.ReadAll(brotli.NewReader(bytes.NewReader(b)))
+	if err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	return decoded
+}
+
+func TestBrotliBody(t *testing.T) {
+	random := make([]byte, brotliBlockSize+100)
+	rand.New(rand.NewSource(1)).Read(random)
+	allBytes := []byte{}
+	for i := 0; i < 1024; i++ {
+		allBytes = append(allBytes, byte(i))
+	}
+	// Fibonacci frequencies make the Huffman code longer than 15 bits unless it is limited.
+	skewed := []byte{}
+	for i, a, b := 0, 1, 1; i < 24; i, a, b = i+1, b, a+b {
+		skewed = append(skewed, bytes.Repeat([]byte{byte('a' + i)}, a)...)
+	}
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), brotliBlockSize/20)
+
+	cases := []struct {
+		name       string
+		body       []byte
+		compressed bool
+	}{
+		{name: "Empty", body: []byte{}},
+		{name: "OneByte", body: []byte("a")},
+		{name: "Short", body: []byte("hello brotli")},
+		{name: "SingleLiteral", body: bytes.Repeat([]byte("a"), 1000), compressed: true},
+		{name: "TwoLiterals", body: bytes.Repeat([]byte("ab"), 1000), compressed: true},
+		{name: "AllBytes", body: allBytes},
+		{name: "Skewed", body: skewed, compressed: true},
+		{name: "Text", body: text, compressed: true},
+		{name: "Random", body: random},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := brotliBody(c.body)
+			decoded := decodeBrotli(t, b)
+			if !bytes.Equal(decoded, c.body) {
+				t.Errorf("decoded body does not match: expect %d bytes, got %d bytes", len(c.body), len(decoded))
+			}
+			if c.compressed && len(b) >= len(c.body)*3/4 {
+				t.Errorf("body is not compressed: %d bytes to %d bytes", len(c.body), len(b))
+			}
+		})
+	}
+
+	if b := brotliBody(nil); !bytes.Equal(b, []byte{0x06}) {
+		t.Errorf("empty stream: expect 0x06, got %x", b)
+	}
+}
+
+func TestNewResponseBrotli(t *testing.T) {
+	r := newResponse(&responseConfig{
+		statusCode: 200,
+		body:       []byte("OK"),
+		headers:    http.Header{"Content-Encoding": {"identity"}},
+		brotli:     true,
+	}, &serverConfig{headers: http.Header{}})
+
+	// explicit headers take precedence as with gzip
+	if r.headers.Get("Content-Encoding") != "identity" {
+		t.Errorf("Content-Encoding: expect identity, got %q", r.headers.Get("Content-Encoding"))
+	}
+
+	r = newResponse(&responseConfig{
+		statusCode: 200,
+		body:       []byte("OK"),
+		brotli:     true,
+	}, &serverConfig{headers: http.Header{}, bodyPrefix: []byte("<"), bodySuffix: []byte(">")})
+
+	if r.headers.Get("Content-Encoding") != "br" {
+		t.Errorf("Content-Encoding: expect br, got %q", r.headers.Get("Content-Encoding"))
+	}
+	if decoded := decodeBrotli(t, r.body); string(decoded) != "<OK>" {
+		t.Errorf("decoded body: expect %q, got %q", "<OK>", decoded)
+	}
+}
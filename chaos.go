@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The behaviors --chaos applies to each request.
+const (
+	chaosNormal   = "normal"
+	chaosDelay    = "delay"
+	chaosDrop     = "drop"
+	chaosTruncate = "truncate"
+)
+
+// chaosWeight is the relative weight of a behavior of --chaos.
+type chaosWeight struct {
+	behavior string
+	weight   int
+}
+
+// parseChaos parses comma separated <behavior>:<weight> pairs.
+// Behaviors not given have no weight.
+func parseChaos(s string) ([]chaosWeight, error) {
+	weights := []chaosWeight{}
+	seen := map[string]bool{}
+	total := 0
+	for _, pair := range strings.Split(s, ",") {
+		behavior, w, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid chaos weight: %q", pair)
+		}
+		switch behavior {
+		case chaosNormal, chaosDelay, chaosDrop, chaosTruncate:
+		default:
+			return nil, fmt.Errorf("chaos behavior must be normal, delay, drop or truncate: %q", behavior)
+		}
+		if seen[behavior] {
+			return nil, fmt.Errorf("duplicate chaos behavior: %q", behavior)
+		}
+		seen[behavior] = true
+		weight, err := strconv.Atoi(w)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("chaos weight must be a non-negative integer: %q", pair)
+		}
+		weights = append(weights, chaosWeight{behavior, weight})
+		total += weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("chaos weights must not be all zero: %q", s)
+	}
+	return weights, nil
+}
+
+// pickChaos picks a behavior at the probability of its weight.
+func pickChaos(rnd *rand.Rand, weights []chaosWeight) string {
+	total := 0
+	for _, w := range weights {
+		total += w.weight
+	}
+	n := rnd.Intn(total)
+	for _, w := range weights {
+		if n < w.weight {
+			return w.behavior
+		}
+		n -= w.weight
+	}
+	return chaosNormal
+}
+
+// chaosFor picks the behavior applied to the response, which is normal if it has no chaos.
+func (h *handler) chaosFor(resp *response) string {
+	if resp.chaos == nil {
+		return chaosNormal
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return pickChaos(h.chaosRand, resp.chaos)
+}
+
+// writeTruncated writes the first half of the body with the Content-Length of the whole
+// and closes the connection.
+func (h *handler) writeTruncated(w http.ResponseWriter, resp *response, body []byte) {
+	h.setHeaders(w, resp, body)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(resp.statusCode)
+	w.Write(body[:len(body)/2])
+	http.NewResponseController(w).Flush()
+	panic(http.ErrAbortHandler)
+}
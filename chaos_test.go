@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseChaos(t *testing.T) {
+	cases := []struct {
+		name   string
+		arg    string
+		expect []chaosWeight
+	}{
+		{name: "Single", arg: "drop:1", expect: []chaosWeight{{chaosDrop, 1}}},
+		{name: "All", arg: "normal:6,delay:2,drop:1,truncate:1", expect: []chaosWeight{{chaosNormal, 6}, {chaosDelay, 2}, {chaosDrop, 1}, {chaosTruncate, 1}}},
+		{name: "ZeroWeight", arg: "normal:1,drop:0", expect: []chaosWeight{{chaosNormal, 1}, {chaosDrop, 0}}},
+		{name: "UnknownBehavior", arg: "normal:1,explode:1"},
+		{name: "MissingWeight", arg: "normal"},
+		{name: "NegativeWeight", arg: "normal:2,drop:-1"},
+		{name: "Duplicate", arg: "drop:1,drop:2"},
+		{name: "AllZero", arg: "normal:0,drop:0"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			weights, err := parseChaos(c.arg)
+			if c.expect == nil {
+				if err == nil {
+					t.Errorf("error was expected but got: %v", weights)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error was not expected but got: %#v", err)
+			}
+			if !reflect.DeepEqual(weights, c.expect) {
+				t.Errorf("expect %v, got %v", c.expect, weights)
+			}
+		})
+	}
+}
+
+func TestPickChaos(t *testing.T) {
+	weights, err := parseChaos("normal:5,delay:3,drop:0,truncate:2")
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	seed := int64(1)
+	rnd := newRand(&seed)
+
+	const picks = 10000
+	counts := map[string]int{}
+	for i := 0; i < picks; i++ {
+		counts[pickChaos(rnd, weights)]++
+	}
+
+	expect := map[string]float64{chaosNormal: 0.5, chaosDelay: 0.3, chaosDrop: 0, chaosTruncate: 0.2}
+	for behavior, ratio := range expect {
+		if got := float64(counts[behavior]) / picks; math.Abs(got-ratio) > 0.02 {
+			t.Errorf("%s: expect ratio %.2f, got %.3f", behavior, ratio, got)
+		}
+	}
+
+	// the same seed applies the same behaviors
+	other := newRand(&seed)
+	rnd = newRand(&seed)
+	for i := 0; i < 100; i++ {
+		if a, b := pickChaos(rnd, weights), pickChaos(other, weights); a != b {
+			t.Fatalf("pick %d differs with the same seed: %s and %s", i, a, b)
+		}
+	}
+}
+
+func TestServerChaos(t *testing.T) {
+	cases := []struct {
+		name        string
+		chaos       string
+		expectError error
+	}{
+		{name: "Normal", chaos: "normal:1"},
+		{name: "Drop", chaos: "drop:1", expectError: io.EOF},
+		{name: "Truncate", chaos: "truncate:1", expectError: io.ErrUnexpectedEOF},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			sc, err := parseArgs([]string{"200", "0123456789", "--chaos", c.chaos})
+			if err != nil {
+				t.Fatalf("error was not expected but got: %#v", err)
+			}
+			handler := newHandler(sc, func() {})
+			handler.logger.out = io.Discard
+			ts := httptest.NewServer(handler)
+			defer ts.Close()
+
+			resp, err := http.Get(ts.URL)
+			if err == nil {
+				var body []byte
+				body, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err == nil && string(body) != "0123456789" {
+					t.Errorf("expect the whole body, got %q", body)
+				}
+			}
+			if !errors.Is(err, c.expectError) {
+				t.Errorf("expect error %v, got %v", c.expectError, err)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTPChaosTruncateHeaders(t *testing.T) {
+	c, err := parseArgs([]string{"--merge-headers", "200", "hello world", "-H", "X-A: 1", "-H", "X-A: 2", "--bad-digest", "sha256", "--chaos", "truncate:1"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %s", err)
+	}
+	handler := newHandler(c, func() {})
+	handler.logger.out = io.Discard
+	w := httptest.NewRecorder()
+
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("truncated response is expected to abort, but recovered %v", err)
+			}
+		}()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	}()
+
+	// the headers are the same as those of the untruncated response
+	if v := w.Header().Values("X-A"); len(v) != 1 || v[0] != "1, 2" {
+		t.Errorf("X-A is expected to be merged, but got: %q", v)
+	}
+	if w.Header().Get("Content-Digest") == "" || w.Header().Get("Digest") == "" {
+		t.Errorf("bad digests are expected, but got: %v", w.Header())
+	}
+	if w.Body.String() != "hello" {
+		t.Errorf("expect the first half %q, got: %q", "hello", w.Body.String())
+	}
+}
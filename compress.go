@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+)
+
+// gzipBody compresses the body with gzip at the level.
+// Zero level means gzip.DefaultCompression.
+func gzipBody(body []byte, level int) []byte {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	buf := &bytes.Buffer{}
+	w, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		// the level is validated by the parser
+		w = gzip.NewWriter(buf)
+	}
+	w.Write(body)
+	w.Close()
+	return buf.Bytes()
+}
+
+// gzipBombBlockSize is the number of zeros in the deflate block repeated by gzipBombBody.
+const gzipBombBlockSize = 1 << 20
+
+// gzipBombBody returns a gzip stream decompressing to size zero bytes.
+// The stream is about a thousandth of the size since deflate cannot compress better than that.
+// Only one block of zeros is compressed and repeated, which is valid since the block refers
+// to no data before it, so that a large size does not take long.
+func gzipBombBody(size int64) []byte {
+	buf := &bytes.Buffer{}
+	// the header of gzip.Writer at gzip.BestCompression
+	buf.Write([]byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 2, 255})
+
+	if blocks := size / gzipBombBlockSize; blocks > 0 {
+		block := &bytes.Buffer{}
+		w, _ := flate.NewWriter(block, flate.BestCompression)
+		w.Write(make([]byte, gzipBombBlockSize))
+		// the sync flush ends the block at a byte boundary to be repeated
+		w.Flush()
+		buf.Grow(int(blocks) * block.Len())
+		for i := int64(0); i < blocks; i++ {
+			buf.Write(block.Bytes())
+		}
+	}
+	w, _ := flate.NewWriter(buf, flate.BestCompression)
+	w.Write(make([]byte, size%gzipBombBlockSize))
+	w.Close()
+
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer, crc32Zeros(size))
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(size))
+	buf.Write(trailer)
+	return buf.Bytes()
+}
+
+// crc32Zeros returns the IEEE CRC-32 of n zero bytes without computing over them,
+// applying the zeros to the register by squaring the operator of a zero bit like zlib's crc32_combine.
+func crc32Zeros(n int64) uint32 {
+	odd := make([]uint32, 32)  // operator for an odd power of two zero bits
+	even := make([]uint32, 32) // operator for an even power of two zero bits
+	odd[0] = 0xedb88320        // the reversed IEEE polynomial
+	row := uint32(1)
+	for i := 1; i < 32; i++ {
+		odd[i] = row
+		row <<= 1
+	}
+	gf2MatrixSquare(even, odd) // two zero bits
+	gf2MatrixSquare(odd, even) // four zero bits
+
+	crc := uint32(0xffffffff)
+	for n > 0 {
+		gf2MatrixSquare(even, odd)
+		if n&1 != 0 {
+			crc = gf2MatrixTimes(even, crc)
+		}
+		n >>= 1
+		if n == 0 {
+			break
+		}
+		gf2MatrixSquare(odd, even)
+		if n&1 != 0 {
+			crc = gf2MatrixTimes(odd, crc)
+		}
+		n >>= 1
+	}
+	return ^crc
+}
+
+func gf2MatrixTimes(mat []uint32, vec uint32) uint32 {
+	sum := uint32(0)
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, mat []uint32) {
+	for i := range square {
+		square[i] = gf2MatrixTimes(mat, mat[i])
+	}
+}
+
+// validGzipLevel reports whether the level is accepted by --gzip-level.
+func validGzipLevel(level int) bool {
+	return level == gzip.DefaultCompression || (gzip.BestSpeed <= level && level <= gzip.BestCompression)
+}
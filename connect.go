@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// connectDialTimeout is the timeout of dialing the target of a CONNECT tunnel.
+const connectDialTimeout = 10 * time.Second
+
+// serveConnect answers the CONNECT request with connectStatus,
+// or relays the connection to the requested host if connectTunnel is set.
+func (h *handler) serveConnect(w http.ResponseWriter, r *http.Request) {
+	if !h.connectTunnel {
+		http.Error(w, http.StatusText(h.connectStatus), h.connectStatus)
+		return
+	}
+
+	target, err := net.DialTimeout("tcp", r.Host, connectDialTimeout)
+	if err != nil {
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Failed to connect to %s: %v", r.Host, err))
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		h.logWriteError(err)
+		return
+	}
+	conn, bufrw, err := rc.Hijack()
+	if err != nil {
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Failed to establish tunnel: %v", err))
+		return
+	}
+	defer conn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// bytes the client sent after the request may be buffered already
+		io.Copy(target, bufrw)
+		if tc, ok := target.(*net.TCPConn); ok {
+			tc.CloseWrite()
+		}
+	}()
+	io.Copy(conn, target)
+	// the client may keep sending after the target finished
+	conn.Close()
+	wg.Wait()
+}
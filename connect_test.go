@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// sendConnect sends CONNECT to the target through the server and returns the connection and its reader.
+func sendConnect(t *testing.T, server *httptest.Server, target string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial failed: %s", err)
+	}
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		t.Fatalf("reading CONNECT response failed: %s", err)
+	}
+	return conn, br, resp
+}
+
+func TestHandler_ServeHTTPConnectStatus(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("sequence")},
+		},
+		shutdownServer: func() {},
+		connectStatus:  405,
+	}
+	handler.logger.out = io.Discard
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	conn, _, resp := sendConnect(t, s, "example.com:443")
+	defer conn.Close()
+
+	if resp.StatusCode != 405 {
+		t.Errorf("expect 405, but got %d", resp.StatusCode)
+	}
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if handler.pos != 0 {
+		t.Errorf("CONNECT is expected not to consume the sequence, but next is %d", handler.pos)
+	}
+}
+
+func TestHandler_ServeHTTPConnectTunnel(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "through the tunnel")
+	}))
+	defer backend.Close()
+
+	handler := &handler{
+		shutdownServer: func() {},
+		connectTunnel:  true,
+	}
+	handler.logger.out = io.Discard
+	handler.logger.errOut = io.Discard
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	target := backend.Listener.Addr().String()
+	conn, br, resp := sendConnect(t, s, target)
+	defer conn.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expect 200, but got %d", resp.StatusCode)
+	}
+
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", target)
+	tunneled, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading tunneled response failed: %s", err)
+	}
+	defer tunneled.Body.Close()
+	body, err := io.ReadAll(tunneled.Body)
+	if err != nil {
+		t.Fatalf("reading tunneled body failed: %s", err)
+	}
+	if string(body) != "through the tunnel" {
+		t.Errorf("expect the response of the target, but got %q", body)
+	}
+
+	// unreachable targets are answered by 502
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %s", err)
+	}
+	addr := unreachable.Addr().String()
+	unreachable.Close()
+	conn2, _, resp2 := sendConnect(t, s, addr)
+	defer conn2.Close()
+	if resp2.StatusCode != 502 {
+		t.Errorf("expect 502, but got %d", resp2.StatusCode)
+	}
+}
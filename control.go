@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// controlRequest is the body of requests to the control path.
+type controlRequest struct {
+	// Action is one of:
+	//   - "reset": serve the sequence of responses from the first again, restore the request quota,
+	//     expect the counter of the count header from 1 again, serve the responses of each path
+	//     from the first again and forget the results held for asynchronous requests
+	//   - "skip": advance the sequence without serving the next response
+	//   - "pause": hold incoming requests until resumed, without serving responses
+	//   - "resume": serve the held and incoming requests again
+	Action string `json:"action"`
+}
+
+// controlResponse is the body of responses from the control path.
+type controlResponse struct {
+	// Next is the index of the next response.
+	Next int `json:"next"`
+	// Paused is whether incoming requests are held.
+	Paused bool `json:"paused"`
+}
+
+// serveControl serves the requests to the control path.
+// They are not counted as requests nor logged.
+func (h *handler) serveControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req controlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid control request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	switch req.Action {
+	case "reset":
+		h.mu.Lock()
+		h.pos = 0
+		h.servedAhead = nil
+		h.quotaUsed = 0
+		h.lastCount = 0
+		h.alwaysNext = nil
+		h.asyncResults = nil
+		h.asyncDone = false
+		h.mu.Unlock()
+	case "skip":
+		resp, isLast := h.getResponse(nil)
+		if resp == nil {
+			http.Error(w, "no response is left to skip", http.StatusConflict)
+			return
+		}
+		if isLast {
+			go h.shutdownServer()
+		}
+	case "pause":
+		h.mu.Lock()
+		if h.resumeCh == nil {
+			h.resumeCh = make(chan struct{})
+		}
+		h.mu.Unlock()
+	case "resume":
+		h.mu.Lock()
+		if h.resumeCh != nil {
+			close(h.resumeCh)
+			h.resumeCh = nil
+		}
+		h.mu.Unlock()
+	default:
+		http.Error(w, fmt.Sprintf("unknown action: %q", req.Action), http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	resp := controlResponse{Next: h.pos, Paused: h.resumeCh != nil}
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// waitResumed blocks while the handler is paused.
+// It returns false if the request is canceled meanwhile.
+func (h *handler) waitResumed(r *http.Request) bool {
+	h.mu.Lock()
+	ch := h.resumeCh
+	h.mu.Unlock()
+	if ch == nil {
+		return true
+	}
+	select {
+	case <-ch:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
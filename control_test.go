@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newControlRequest(action string) *http.Request {
+	return httptest.NewRequest("POST", "/_control", strings.NewReader(`{"action":"`+action+`"}`))
+}
+
+func TestHandler_ServeControl(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("first")},
+			{statusCode: 200, body: []byte("second")},
+			{statusCode: 200, body: []byte("third")},
+		},
+		shutdownServer: func() {},
+		controlPath:    "/_control",
+	}
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	expectBody := func(w *httptest.ResponseRecorder, expect string) {
+		t.Helper()
+		if w.Body.String() != expect {
+			t.Errorf("body does not match: expect %s, got: %s", expect, w.Body.String())
+		}
+	}
+	expectNext := func(w *httptest.ResponseRecorder, expect int) {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("control request failed: %d %s", w.Code, w.Body.String())
+		}
+		var resp controlResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("control response is not JSON: %s", err)
+		}
+		if resp.Next != expect {
+			t.Errorf("next: expect %d, got: %d", expect, resp.Next)
+		}
+	}
+
+	expectBody(serve(httptest.NewRequest("GET", "/", nil)), "first")
+	expectBody(serve(httptest.NewRequest("GET", "/", nil)), "second")
+
+	expectNext(serve(newControlRequest("reset")), 0)
+	expectBody(serve(httptest.NewRequest("GET", "/", nil)), "first")
+
+	expectNext(serve(newControlRequest("skip")), 2)
+	expectBody(serve(httptest.NewRequest("GET", "/", nil)), "third")
+
+	if handler.requestCount != 4 {
+		t.Errorf("control requests should not be counted, but requestCount is %d", handler.requestCount)
+	}
+}
+
+func TestHandler_ServeControlRequestQuota(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("first"), untilSignal: true},
+		},
+		shutdownServer: func() {},
+		controlPath:    "/_control",
+		requestQuota:   2,
+	}
+	handler.logger.out = io.Discard
+
+	serve := func(r *http.Request) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i, expect := range []int{200, 200, 429, 429} {
+		if code := serve(httptest.NewRequest("GET", "/", nil)); code != expect {
+			t.Errorf("request %d: expect %d, got: %d", i, expect, code)
+		}
+	}
+
+	if code := serve(newControlRequest("reset")); code != http.StatusOK {
+		t.Fatalf("control request failed: %d", code)
+	}
+	if code := serve(httptest.NewRequest("GET", "/", nil)); code != http.StatusOK {
+		t.Errorf("request after reset: expect 200, got: %d", code)
+	}
+}
+
+func TestHandler_ServeControlResetState(t *testing.T) {
+	always := []*response{
+		{statusCode: 200, body: []byte("a")},
+		{statusCode: 200, body: []byte("b")},
+	}
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("first")},
+			{statusCode: 200, body: []byte("second")},
+		},
+		always:         map[string][]*response{"/rr": always},
+		shutdownServer: func() {},
+		controlPath:    "/_control",
+		preferAsync:    true,
+	}
+	handler.logger.out = io.Discard
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if body := serve(httptest.NewRequest("GET", "/rr", nil)).Body.String(); body != "a" {
+		t.Fatalf("expect %q, got: %q", "a", body)
+	}
+	async := httptest.NewRequest("GET", "/", nil)
+	async.Header.Set("Prefer", "respond-async")
+	location := serve(async).Header().Get("Location")
+	if location == "" {
+		t.Fatal("the asynchronous request is not accepted")
+	}
+
+	if code := serve(newControlRequest("reset")).Code; code != http.StatusOK {
+		t.Fatalf("control request failed: %d", code)
+	}
+
+	if body := serve(httptest.NewRequest("GET", "/rr", nil)).Body.String(); body != "a" {
+		t.Errorf("round robin after reset: expect %q, got: %q", "a", body)
+	}
+	if code := serve(httptest.NewRequest("GET", location, nil)).Code; code != http.StatusNotFound {
+		t.Errorf("result held before reset: expect 404, got: %d", code)
+	}
+	if body := serve(httptest.NewRequest("GET", "/", nil)).Body.String(); body != "first" {
+		t.Errorf("sequence after reset: expect %q, got: %q", "first", body)
+	}
+}
+
+func TestHandler_ServeControlPause(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("first")},
+		},
+		shutdownServer: func() {},
+		controlPath:    "/_control",
+	}
+	handler.logger.out = io.Discard
+
+	control := func(action string, expectPaused bool) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newControlRequest(action))
+		var resp controlResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("control response is not JSON: %s", err)
+		}
+		if resp.Paused != expectPaused {
+			t.Errorf("%s: paused: expect %v, got: %v", action, expectPaused, resp.Paused)
+		}
+	}
+
+	control("pause", true)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		done <- w
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("request is expected to be held while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+	handler.mu.Lock()
+	pos := handler.pos
+	handler.mu.Unlock()
+	if pos != 0 {
+		t.Errorf("held request should not consume responses, but next is %d", pos)
+	}
+
+	control("resume", false)
+
+	select {
+	case w := <-done:
+		if w.Body.String() != "first" {
+			t.Errorf("body does not match: expect first, got: %s", w.Body.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("request is expected to complete after resumed")
+	}
+}
+
+func TestHandler_ServeControlFailure(t *testing.T) {
+	cases := []struct {
+		name       string
+		req        *http.Request
+		expectCode int
+	}{
+		{
+			name:       "GET",
+			req:        httptest.NewRequest("GET", "/_control", nil),
+			expectCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "InvalidJSON",
+			req:        httptest.NewRequest("POST", "/_control", bytes.NewReader([]byte("reset"))),
+			expectCode: http.StatusBadRequest,
+		},
+		{
+			name:       "UnknownAction",
+			req:        newControlRequest("rewind"),
+			expectCode: http.StatusBadRequest,
+		},
+		{
+			name:       "SkipWithoutResponse",
+			req:        newControlRequest("skip"),
+			expectCode: http.StatusConflict,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := &handler{
+				responses:      []*response{},
+				shutdownServer: func() {},
+				controlPath:    "/_control",
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, c.req)
+
+			if w.Code != c.expectCode {
+				t.Errorf("code does not match: expect %d, got: %d", c.expectCode, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseCookie parses a cookie spec in the form of a Set-Cookie header value like
+// "name=value; Path=/; Max-Age=60; Secure; HttpOnly; SameSite=Lax".
+// Unlike clients parsing Set-Cookie, it rejects malformed attributes instead of ignoring them.
+func parseCookie(spec string) (*http.Cookie, error) {
+	parts := strings.Split(spec, ";")
+	name, value, ok := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid cookie %q: must start with <name>=<value>", spec)
+	}
+	c := &http.Cookie{Name: name, Value: value}
+
+	for _, attr := range parts[1:] {
+		key, val, hasVal := strings.Cut(strings.TrimSpace(attr), "=")
+		switch strings.ToLower(key) {
+		case "path":
+			c.Path = val
+		case "domain":
+			c.Domain = val
+		case "expires":
+			t, err := time.Parse(http.TimeFormat, val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cookie %q: Expires must be in the format of %q", spec, http.TimeFormat)
+			}
+			c.Expires = t
+		case "max-age":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid cookie %q: Max-Age must be a non-negative integer", spec)
+			}
+			if n == 0 {
+				// http.Cookie represents "Max-Age=0" by a negative value
+				n = -1
+			}
+			c.MaxAge = n
+		case "secure":
+			if hasVal {
+				return nil, fmt.Errorf("invalid cookie %q: Secure does not take a value", spec)
+			}
+			c.Secure = true
+		case "httponly":
+			if hasVal {
+				return nil, fmt.Errorf("invalid cookie %q: HttpOnly does not take a value", spec)
+			}
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(val) {
+			case "lax":
+				c.SameSite = http.SameSiteLaxMode
+			case "strict":
+				c.SameSite = http.SameSiteStrictMode
+			case "none":
+				c.SameSite = http.SameSiteNoneMode
+			default:
+				return nil, fmt.Errorf("invalid cookie %q: SameSite must be Lax, Strict or None", spec)
+			}
+		default:
+			return nil, fmt.Errorf("invalid cookie %q: unknown attribute %q", spec, key)
+		}
+	}
+
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		return nil, fmt.Errorf("invalid cookie %q: SameSite=None requires Secure", spec)
+	}
+	if err := c.Valid(); err != nil {
+		return nil, fmt.Errorf("invalid cookie %q: %w", spec, err)
+	}
+	return c, nil
+}
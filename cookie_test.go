@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseCookieSuccess(t *testing.T) {
+	cases := []struct {
+		spec   string
+		expect string
+	}{
+		{
+			spec:   "session=abc",
+			expect: "session=abc",
+		},
+		{
+			spec:   "session=abc; Path=/; Domain=example.com; Max-Age=3600; HttpOnly; Secure; SameSite=Lax",
+			expect: "session=abc; Path=/; Domain=example.com; Max-Age=3600; HttpOnly; Secure; SameSite=Lax",
+		},
+		{
+			spec:   "id=1;max-age=0;samesite=strict",
+			expect: "id=1; Max-Age=0; SameSite=Strict",
+		},
+		{
+			spec:   "id=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT; SameSite=None; Secure",
+			expect: "id=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Secure; SameSite=None",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.spec, func(t *testing.T) {
+			t.Parallel()
+
+			cookie, err := parseCookie(c.spec)
+			if err != nil {
+				t.Fatalf("error was not expected but got: %#v", err)
+			}
+			if actual := cookie.String(); actual != c.expect {
+				t.Errorf("expect %q, but got %q", c.expect, actual)
+			}
+		})
+	}
+}
+
+func TestParseCookieFailure(t *testing.T) {
+	cases := []string{
+		"",
+		"=value",
+		"novalue",
+		"bad name=value",
+		"id=1; Max-Age=soon",
+		"id=1; Max-Age=-1",
+		"id=1; Expires=tomorrow",
+		"id=1; Secure=yes",
+		"id=1; HttpOnly=true",
+		"id=1; SameSite=Loose",
+		"id=1; SameSite=None",
+		"id=1; Priority=High",
+	}
+
+	for _, spec := range cases {
+		spec := spec
+		t.Run(spec, func(t *testing.T) {
+			t.Parallel()
+
+			if cookie, err := parseCookie(spec); err == nil {
+				t.Errorf("error was expected but got %q", cookie.String())
+			}
+		})
+	}
+}
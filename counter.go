@@ -0,0 +1,23 @@
+package main
+
+import "strconv"
+
+// countedResponse accepts the counter of the countHeader if it is the one expected next,
+// counting from 1, and returns the response at the counter in the sequence, wrapping around in a loop.
+// If the counter is not expected, it returns nil and the expected counter.
+// If the sequence is finished, it returns nil and zero whatever the counter is.
+// In a loop, no response is the last.
+func (h *handler) countedResponse(counter string) (resp *response, isLast bool, expected int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	expected = h.lastCount + 1
+	if !h.loop && expected > len(h.responses) {
+		return nil, false, 0
+	}
+	if counter != strconv.Itoa(expected) {
+		return nil, false, expected
+	}
+	h.lastCount = expected
+	i := (expected - 1) % len(h.responses)
+	return h.responses[i], !h.loop && expected == len(h.responses), expected
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"net/http"
+)
+
+// contentDigestTrailer is the trailer of the checksum of the body (RFC 9530).
+const contentDigestTrailer = "Content-Digest"
+
+// digestAlgorithms are the algorithms of --trailer-checksum and --bad-digest by name
+// along with the names in Content-Digest and in the obsolete Digest header (RFC 3230).
+var digestAlgorithms = map[string]struct {
+	key    string
+	legacy string
+	new    func() hash.Hash
+}{
+	"md5":    {"md5", "MD5", md5.New},
+	"sha256": {"sha-256", "SHA-256", sha256.New},
+}
+
+// contentDigest returns the Content-Digest value of the body by the algorithm.
+func contentDigest(algorithm string, body []byte) string {
+	return digestAlgorithms[algorithm].key + "=:" + base64.StdEncoding.EncodeToString(digestSum(algorithm, body)) + ":"
+}
+
+// setBadDigest sets Content-Digest and Digest by the algorithm that never match the body.
+func setBadDigest(h http.Header, algorithm string, body []byte) {
+	sum := digestSum(algorithm, body)
+	sum[0] ^= 0xff
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	a := digestAlgorithms[algorithm]
+	h.Set("Content-Digest", a.key+"=:"+encoded+":")
+	h.Set("Digest", a.legacy+"="+encoded)
+}
+
+func digestSum(algorithm string, body []byte) []byte {
+	h := digestAlgorithms[algorithm].new()
+	h.Write(body)
+	return h.Sum(nil)
+}
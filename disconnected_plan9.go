@@ -0,0 +1,9 @@
+//go:build plan9
+
+package main
+
+// isDisconnected reports whether the write error is caused by the client closing the connection.
+// Plan 9 has no errno telling it, so no error is taken for a disconnection.
+func isDisconnected(err error) bool {
+	return false
+}
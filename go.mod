@@ -1,3 +1,5 @@
 module github.com/watarena/mock-server
 
 go 1.21
+
+require github.com/andybalholm/brotli v1.1.1
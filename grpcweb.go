@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+)
+
+const (
+	grpcWebDataFrame    byte = 0x00
+	grpcWebTrailerFrame byte = 0x80
+)
+
+// grpcWebTrailers is the trailer frame telling the client the call succeeded.
+var grpcWebTrailers = []byte("grpc-status: 0\r\n")
+
+// grpcWebFrame frames the message as a gRPC-Web response:
+// a length-prefixed data frame followed by a trailer frame.
+// If text is true, the frames are base64 encoded as in application/grpc-web-text.
+func grpcWebFrame(message []byte, text bool) []byte {
+	buf := &bytes.Buffer{}
+	writeGRPCWebFrame(buf, grpcWebDataFrame, message)
+	writeGRPCWebFrame(buf, grpcWebTrailerFrame, grpcWebTrailers)
+	if !text {
+		return buf.Bytes()
+	}
+	return []byte(base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+func writeGRPCWebFrame(buf *bytes.Buffer, flag byte, payload []byte) {
+	buf.WriteByte(flag)
+	binary.Write(buf, binary.BigEndian, uint32(len(payload)))
+	buf.Write(payload)
+}
+
+func grpcWebContentType(text bool) string {
+	if text {
+		return "application/grpc-web-text+proto"
+	}
+	return "application/grpc-web+proto"
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"testing"
+)
+
+type grpcWebTestFrame struct {
+	flag    byte
+	payload []byte
+}
+
+func readGRPCWebFrames(t *testing.T, b []byte) []grpcWebTestFrame {
+	t.Helper()
+	frames := []grpcWebTestFrame{}
+	r := bytes.NewReader(b)
+	for r.Len() > 0 {
+		var header [5]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			t.Fatalf("reading frame header failed: %s", err)
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			t.Fatalf("reading frame payload failed: %s", err)
+		}
+		frames = append(frames, grpcWebTestFrame{header[0], payload})
+	}
+	return frames
+}
+
+func TestNewResponseGRPCWeb(t *testing.T) {
+	cases := []struct {
+		name              string
+		text              bool
+		expectContentType string
+	}{
+		{name: "Binary", text: false, expectContentType: "application/grpc-web+proto"},
+		{name: "Text", text: true, expectContentType: "application/grpc-web-text+proto"},
+	}
+
+	message := []byte("\x0a\x05hello")
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := newResponse(&responseConfig{
+				statusCode:  200,
+				body:        message,
+				grpcWeb:     true,
+				grpcWebText: c.text,
+			}, &serverConfig{headers: http.Header{}})
+
+			if ct := r.headers.Get("Content-Type"); ct != c.expectContentType {
+				t.Errorf("Content-Type: expect %s, but got %s", c.expectContentType, ct)
+			}
+
+			body := r.body
+			if c.text {
+				decoded, err := base64.StdEncoding.DecodeString(string(body))
+				if err != nil {
+					t.Fatalf("body is not base64: %s", err)
+				}
+				body = decoded
+			}
+			frames := readGRPCWebFrames(t, body)
+			if len(frames) != 2 {
+				t.Fatalf("2 frames are expected but got %d", len(frames))
+			}
+			if frames[0].flag != grpcWebDataFrame || !bytes.Equal(frames[0].payload, message) {
+				t.Errorf("data frame does not match: %#v", frames[0])
+			}
+			if frames[1].flag != grpcWebTrailerFrame || !bytes.Contains(frames[1].payload, []byte("grpc-status: 0")) {
+				t.Errorf("trailer frame does not match: %#v", frames[1])
+			}
+		})
+	}
+}
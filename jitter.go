@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"net/http"
+	"time"
+)
+
+// jitterFor returns the random delay of up to jitter added to the response to the request.
+// With jitterByPath, it is derived from the hash of the path and the seed instead,
+// so that requests to the same path always get the same delay.
+func (h *handler) jitterFor(r *http.Request) time.Duration {
+	if h.jitter <= 0 {
+		return 0
+	}
+	if h.jitterByPath {
+		hash := fnv.New64a()
+		binary.Write(hash, binary.BigEndian, h.jitterSeed)
+		hash.Write([]byte(r.URL.Path))
+		return time.Duration(hash.Sum64() % uint64(h.jitter))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Duration(h.jitterRand.Int63n(int64(h.jitter)))
+}
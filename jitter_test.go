@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandler_JitterFor(t *testing.T) {
+	const jitter = time.Second
+	newJitterHandler := func(seed int64, byPath bool) *handler {
+		return newHandler(&serverConfig{jitter: jitter, jitterByPath: byPath, seed: &seed}, func() {})
+	}
+	jitterOf := func(h *handler, path string) time.Duration {
+		return h.jitterFor(httptest.NewRequest("GET", path, nil))
+	}
+
+	t.Run("ByPath", func(t *testing.T) {
+		t.Parallel()
+
+		h := newJitterHandler(1, true)
+		paths := []string{"/", "/users", "/users/1", "/orders"}
+		delays := map[string]time.Duration{}
+		for _, path := range paths {
+			delays[path] = jitterOf(h, path)
+			if d := delays[path]; d < 0 || d >= jitter {
+				t.Errorf("%s: jitter out of range: %s", path, d)
+			}
+		}
+
+		// the same path gets the same delay in any order and in another handler of the same seed
+		other := newJitterHandler(1, true)
+		for i := len(paths) - 1; i >= 0; i-- {
+			path := paths[i]
+			if d := jitterOf(h, path); d != delays[path] {
+				t.Errorf("%s: expect %s again, got: %s", path, delays[path], d)
+			}
+			if d := jitterOf(other, path); d != delays[path] {
+				t.Errorf("%s: expect %s with the same seed, got: %s", path, delays[path], d)
+			}
+		}
+
+		if jitterOf(h, "/users") == jitterOf(h, "/orders") {
+			t.Error("different paths are expected to get different delays")
+		}
+		if jitterOf(newJitterHandler(2, true), "/users") == delays["/users"] {
+			t.Error("another seed is expected to give another delay")
+		}
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		t.Parallel()
+
+		h := newJitterHandler(1, false)
+		for i := 0; i < 100; i++ {
+			if d := jitterOf(h, "/"); d < 0 || d >= jitter {
+				t.Fatalf("jitter out of range: %s", d)
+			}
+		}
+		// the delays are reproducible by the seed
+		a, b := newJitterHandler(1, false), newJitterHandler(1, false)
+		for i := 0; i < 10; i++ {
+			if da, db := jitterOf(a, "/"), jitterOf(b, "/"); da != db {
+				t.Fatalf("expect the same delays with the same seed, got: %s and %s", da, db)
+			}
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(&serverConfig{}, func() {})
+		if d := jitterOf(h, "/"); d != 0 {
+			t.Errorf("expect no jitter, got: %s", d)
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// connRequestsKey is the context key of the number of requests served on the connection.
+type connRequestsKey struct{}
+
+// withConnRequests adds the counter of requests on the connection to its context.
+// It is the ConnContext of the server.
+func withConnRequests(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connRequestsKey{}, new(atomic.Int64))
+}
+
+// closeAfterMaxRequests counts the request on its connection and makes the connection close
+// after the response once maxRequestsPerConn requests are served on it.
+func (h *handler) closeAfterMaxRequests(w http.ResponseWriter, r *http.Request) {
+	n, ok := r.Context().Value(connRequestsKey{}).(*atomic.Int64)
+	if !ok {
+		return
+	}
+	if n.Add(1) >= int64(h.maxRequestsPerConn) {
+		// net/http closes the connection after the response with this header over HTTP/1.x
+		w.Header().Set("Connection", "close")
+	}
+}
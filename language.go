@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// languageQuality returns the quality of the language tag in the Accept-Language header,
+// or 0 if the tag is not acceptable. Tags match if they are equal or one is a prefix of
+// the other, such as "en" and "en-US".
+func languageQuality(acceptLanguage, tag string) float64 {
+	best := 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		accepted, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		accepted = strings.TrimSpace(accepted)
+		if accepted == "" || !languageMatches(accepted, tag) {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		best = max(best, q)
+	}
+	return best
+}
+
+func languageMatches(accepted, tag string) bool {
+	accepted = strings.ToLower(accepted)
+	tag = strings.ToLower(tag)
+	return accepted == "*" || accepted == tag ||
+		strings.HasPrefix(tag, accepted+"-") || strings.HasPrefix(accepted, tag+"-")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+)
+
+func TestLanguageQuality(t *testing.T) {
+	cases := []struct {
+		acceptLanguage string
+		tag            string
+		expect         float64
+	}{
+		{acceptLanguage: "en", tag: "en", expect: 1},
+		{acceptLanguage: "en-US", tag: "en", expect: 1},
+		{acceptLanguage: "en", tag: "en-US", expect: 1},
+		{acceptLanguage: "EN-us", tag: "en-US", expect: 1},
+		{acceptLanguage: "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", tag: "en", expect: 0.8},
+		{acceptLanguage: "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", tag: "de", expect: 0.5},
+		{acceptLanguage: "eng", tag: "en", expect: 0},
+		{acceptLanguage: "en;q=0", tag: "en", expect: 0},
+		{acceptLanguage: "", tag: "en", expect: 0},
+	}
+
+	for _, c := range cases {
+		if q := languageQuality(c.acceptLanguage, c.tag); q != c.expect {
+			t.Errorf("%q for %q: expect %v, but got %v", c.tag, c.acceptLanguage, c.expect, q)
+		}
+	}
+}
+
+func TestHandler_ServeHTTPMatchLanguage(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("bonjour"), matchLanguage: "fr"},
+			{statusCode: 200, body: []byte("hello"), matchLanguage: "en"},
+			{statusCode: 200, body: []byte("default")},
+		},
+		shutdownServer:   func() {},
+		notFoundTemplate: template.Must(template.New("").Parse("not found")),
+	}
+	handler.logger.out = io.Discard
+
+	steps := []struct {
+		acceptLanguage string
+		expectBody     string
+	}{
+		// the best match is preferred to the first one
+		{acceptLanguage: "en-US, fr;q=0.5", expectBody: "hello"},
+		{acceptLanguage: "de", expectBody: "default"},
+		{acceptLanguage: "de", expectBody: "not found"},
+		{acceptLanguage: "fr-CA", expectBody: "bonjour"},
+	}
+
+	for i, s := range steps {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", s.acceptLanguage)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Body.String() != s.expectBody {
+			t.Errorf("step %d: expect %q, got: %q", i, s.expectBody, w.Body.String())
+		}
+	}
+}
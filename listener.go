@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sdListenFdsStart is the first file descriptor passed by systemd socket activation.
+const sdListenFdsStart = 3
+
+// endpoint is a listener the server accepts connections on.
+type endpoint struct {
+	net.Listener
+	// tls serves TLS on the listener.
+	tls bool
+}
+
+func (e endpoint) scheme() string {
+	if e.tls {
+		return "https"
+	}
+	return "http"
+}
+
+// listenAll creates all the listeners the server accepts connections on.
+func listenAll(c *serverConfig) ([]endpoint, error) {
+	l, err := listen(c)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := []endpoint{{l, c.tls != nil && c.httpsAddr == ""}}
+	closeAll := func() {
+		for _, e := range endpoints {
+			e.Close()
+		}
+	}
+
+	if c.httpsAddr != "" {
+		tl, err := listenRetry(listenNetwork(c), c.httpsAddr, c.listenRetry)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint{tl, true})
+	}
+
+	if c.dualStack {
+		// the IPv6 listeners take the ports of the IPv4 ones, which may have been chosen by the system
+		for _, e := range endpoints {
+			port := e.Addr().(*net.TCPAddr).Port
+			l6, err := listenRetry("tcp6", net.JoinHostPort("::", strconv.Itoa(port)), c.listenRetry)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			endpoints = append(endpoints, endpoint{l6, e.tls})
+		}
+	}
+
+	for i, e := range endpoints {
+		endpoints[i].Listener = wrapListener(e.Listener, e.tls, c)
+	}
+	return endpoints, nil
+}
+
+// wrapListener wraps the listener to inject the configured faults.
+func wrapListener(l net.Listener, isTLS bool, c *serverConfig) net.Listener {
+	if c.refuseProbability > 0 {
+		l = &refuseListener{l, c.refuseProbability, newRand(c.seed)}
+	}
+	// the buffer sizes are set before the connections are wrapped since they need *net.TCPConn
+	if c.readBuffer > 0 || c.writeBuffer > 0 {
+		l = &bufferSizeListener{l, c.readBuffer, c.writeBuffer}
+	}
+	if c.maxConnsPerIP > 0 {
+		l = &perIPLimitListener{Listener: l, limit: c.maxConnsPerIP, conns: map[string]int{}}
+	}
+	if c.acceptDelay > 0 {
+		l = &acceptDelayListener{l, c.acceptDelay}
+	}
+	if !isTLS && c.badRequestBody != "" {
+		l = &badRequestListener{l, []byte(c.badRequestBody)}
+	}
+	if isTLS && c.tls.handshakeDelay > 0 {
+		l = &handshakeDelayListener{l, c.tls.handshakeDelay}
+	}
+	return l
+}
+
+// refuseListener closes accepted connections at the probability before serving them.
+type refuseListener struct {
+	net.Listener
+	probability float64
+	// rnd is only used by the accept loop of the listener.
+	rnd *rand.Rand
+}
+
+func (l *refuseListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.rnd.Float64() >= l.probability {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// perIPLimitListener closes accepted connections from remote IPs already having
+// the limit of connections open before serving them.
+type perIPLimitListener struct {
+	net.Listener
+	limit int
+
+	mu sync.Mutex
+	// conns is the number of open connections by remote IP.
+	conns map[string]int
+}
+
+func (l *perIPLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := conn.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		l.mu.Lock()
+		ok := l.conns[ip] < l.limit
+		if ok {
+			l.conns[ip]++
+		}
+		l.mu.Unlock()
+		if ok {
+			return &perIPLimitConn{Conn: conn, release: func() { l.release(ip) }}, nil
+		}
+		conn.Close()
+	}
+}
+
+func (l *perIPLimitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// perIPLimitConn releases its count of the remote IP when closed.
+type perIPLimitConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *perIPLimitConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// bufferSizeListener sets the socket buffer sizes of accepted TCP connections.
+// Zero leaves the size of the system default.
+type bufferSizeListener struct {
+	net.Listener
+	readBuffer  int
+	writeBuffer int
+}
+
+func (l *bufferSizeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		// the sizes are best effort since the system may adjust or reject them
+		if l.readBuffer > 0 {
+			tc.SetReadBuffer(l.readBuffer)
+		}
+		if l.writeBuffer > 0 {
+			tc.SetWriteBuffer(l.writeBuffer)
+		}
+	}
+	return conn, nil
+}
+
+// acceptDelayListener delays returning accepted connections.
+type acceptDelayListener struct {
+	net.Listener
+	delay time.Duration
+}
+
+func (l *acceptDelayListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(l.delay)
+	return conn, nil
+}
+
+// handshakeDelayListener delays the TLS handshake of accepted connections.
+type handshakeDelayListener struct {
+	net.Listener
+	delay time.Duration
+}
+
+func (l *handshakeDelayListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &handshakeDelayConn{Conn: conn, delay: l.delay}, nil
+}
+
+// handshakeDelayConn sleeps before the first read, which is the read of the TLS handshake.
+// Sleeping here rather than in Accept keeps the accept loop unblocked.
+type handshakeDelayConn struct {
+	net.Conn
+	delay time.Duration
+	once  sync.Once
+}
+
+func (c *handshakeDelayConn) Read(b []byte) (int, error) {
+	c.once.Do(func() { time.Sleep(c.delay) })
+	return c.Conn.Read(b)
+}
+
+// listen creates the listener the server accepts connections on.
+func listen(c *serverConfig) (net.Listener, error) {
+	if c.systemd {
+		l, err := systemdListener()
+		if err != nil {
+			return nil, err
+		}
+		if l != nil {
+			return l, nil
+		}
+	}
+	return listenRetry(listenNetwork(c), c.addr, c.listenRetry)
+}
+
+// listenRetryMaxInterval is the maximum interval between retries of listenRetry.
+const listenRetryMaxInterval = time.Second
+
+// listenRetry listens on the address, retrying with backoff for up to retry while it is in use.
+func listenRetry(network, addr string, retry time.Duration) (net.Listener, error) {
+	deadline := time.Now().Add(retry)
+	interval := 50 * time.Millisecond
+	for {
+		l, err := net.Listen(network, addr)
+		if err == nil || !isAddrInUse(err) {
+			return l, err
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil, err
+		}
+		time.Sleep(min(interval, left))
+		interval = min(interval*2, listenRetryMaxInterval)
+	}
+}
+
+// listenNetwork returns the network of the listeners, which is only IPv4 with --dual-stack
+// since the IPv6 listeners are created separately.
+func listenNetwork(c *serverConfig) string {
+	if c.dualStack {
+		return "tcp4"
+	}
+	return "tcp"
+}
+
+// systemdListener returns the listener passed by systemd socket activation,
+// or nil if no socket was passed to this process.
+// Only the first socket is used when systemd passes more than one.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(sdListenFdsStart, "LISTEN_FD_"+strconv.Itoa(sdListenFdsStart))
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// announcement is the JSON printed by --announce-json once the listener is bound.
+type announcement struct {
+	Scheme  string `json:"scheme"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+}
+
+// announce writes the address the listener is bound to as JSON.
+func announce(w io.Writer, addr net.Addr, scheme string) error {
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(announcement{
+		Scheme:  scheme,
+		Address: host,
+		Port:    port,
+	})
+}
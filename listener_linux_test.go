@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestBufferSizeListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+	l = wrapListener(l, false, &serverConfig{readBuffer: 8192, writeBuffer: 16384})
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	defer client.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accept failed: %s", err)
+	}
+	defer conn.Close()
+
+	sc, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn failed: %s", err)
+	}
+	var rcvbuf, sndbuf int
+	sc.Control(func(fd uintptr) {
+		rcvbuf, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		sndbuf, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+
+	// Linux doubles the size set for the bookkeeping overhead
+	if rcvbuf != 2*8192 {
+		t.Errorf("SO_RCVBUF: expect %d, but got %d", 2*8192, rcvbuf)
+	}
+	if sndbuf != 2*16384 {
+		t.Errorf("SO_SNDBUF: expect %d, but got %d", 2*16384, sndbuf)
+	}
+}
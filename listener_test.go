@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key to temporary files.
+// The certificate can also be used as a client certificate and its CA.
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key failed: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mock-server test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:     []string{"localhost"},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate failed: %s", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshaling key failed: %s", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("writing certificate failed: %s", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatalf("writing key failed: %s", err)
+	}
+	return certFile, keyFile
+}
+
+func TestSystemdListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %s", err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("File failed: %s", err)
+	}
+	defer f.Close()
+
+	// the inherited socket is passed as fd 3 to the helper process
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSystemdListenerHelper$")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(),
+		"SYSTEMD_LISTENER_HELPER=1",
+		"LISTEN_FDS=1",
+		"EXPECT_ADDR="+l.Addr().String(),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("helper process failed: %s\n%s", err, out)
+	}
+}
+
+// TestSystemdListenerHelper is run as a subprocess by TestSystemdListener.
+func TestSystemdListenerHelper(t *testing.T) {
+	if os.Getenv("SYSTEMD_LISTENER_HELPER") != "1" {
+		t.Skip("helper process for TestSystemdListener")
+	}
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	l, err := listen(&serverConfig{addr: "127.0.0.1:0", systemd: true})
+	if err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+	defer l.Close()
+
+	if expect := os.Getenv("EXPECT_ADDR"); l.Addr().String() != expect {
+		t.Errorf("listener addr: expect %s, but got %s", expect, l.Addr())
+	}
+}
+
+func TestSystemdListenerFallback(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+
+	l, err := listen(&serverConfig{addr: "127.0.0.1:0", systemd: true})
+	if err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+	defer l.Close()
+
+	if _, ok := l.(*net.TCPListener); !ok {
+		t.Errorf("tcp listener was expected but got %T", l)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	l, err := listen(&serverConfig{addr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+	defer l.Close()
+
+	buf := &bytes.Buffer{}
+	if err := announce(buf, l.Addr(), "https"); err != nil {
+		t.Fatalf("announce failed: %s", err)
+	}
+
+	var actual announcement
+	if err := json.Unmarshal(buf.Bytes(), &actual); err != nil {
+		t.Fatalf("announcement is not JSON: %q: %s", buf.String(), err)
+	}
+	expect := announcement{
+		Scheme:  "https",
+		Address: "127.0.0.1",
+		Port:    l.Addr().(*net.TCPAddr).Port,
+	}
+	if actual != expect {
+		t.Errorf("announcement: expect %#v, but got %#v", expect, actual)
+	}
+}
+
+func TestTLSHandshakeDelay(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	c := &serverConfig{
+		addr:    "127.0.0.1:0",
+		headers: http.Header{},
+		tls: &tlsConfig{
+			certFile:       certFile,
+			keyFile:        keyFile,
+			handshakeDelay: 300 * time.Millisecond,
+		},
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("OK")},
+		},
+	}
+	endpoints, err := listenAll(c)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(c)
+	go server.serveAll(endpoints, c.tls)
+	defer server.Close()
+
+	url := "https://" + endpoints[0].Addr().String()
+	newClient := func(timeout time.Duration) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+				TLSHandshakeTimeout: timeout,
+			},
+		}
+	}
+
+	if _, err := newClient(100 * time.Millisecond).Get(url); err == nil {
+		t.Error("TLS handshake is expected to time out")
+	}
+
+	start := time.Now()
+	resp, err := newClient(time.Second).Get(url)
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("TLS handshake is expected to be delayed, but took %s", elapsed)
+	}
+}
+
+func TestAcceptDelay(t *testing.T) {
+	c := &serverConfig{
+		addr:        "127.0.0.1:0",
+		headers:     http.Header{},
+		acceptDelay: 300 * time.Millisecond,
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("OK")},
+			{statusCode: 200, body: []byte("OK")},
+		},
+	}
+	endpoints, err := listenAll(c)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(c)
+	go server.serveAll(endpoints, c.tls)
+	defer server.Close()
+
+	url := "http://" + endpoints[0].Addr().String()
+
+	// the connection is established by the kernel but not served within the deadline
+	shortClient := &http.Client{Timeout: 100 * time.Millisecond}
+	if _, err := shortClient.Get(url); !os.IsTimeout(err) {
+		t.Errorf("request is expected to time out, but got: %v", err)
+	}
+
+	start := time.Now()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("connection is expected to be delayed, but took %s", elapsed)
+	}
+}
+
+func TestBadRequestBody(t *testing.T) {
+	c := &serverConfig{
+		addr:           "127.0.0.1:0",
+		headers:        http.Header{},
+		badRequestBody: `{"error":"malformed"}`,
+		responses: []*responseConfig{
+			{statusCode: 400, body: []byte("handler")},
+			{statusCode: 200, body: []byte("OK")},
+		},
+	}
+	endpoints, err := listenAll(c)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(c)
+	go server.serveAll(endpoints, c.tls)
+	defer server.Close()
+
+	addr := endpoints[0].Addr().String()
+	tests := []struct {
+		name    string
+		request string
+		expect  string
+	}{
+		{
+			name:    "malformed request line",
+			request: "GET / HTTP/1.1 extra\r\nHost: x\r\n\r\n",
+			expect:  `{"error":"malformed"}`,
+		},
+		{
+			name:    "400 of the handler",
+			request: "GET / HTTP/1.1\r\nHost: x\r\n\r\n",
+			expect:  "handler",
+		},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("%s: dial failed: %s", tt.name, err)
+		}
+		io.WriteString(conn, tt.request)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			conn.Close()
+			t.Fatalf("%s: reading response failed: %s", tt.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		conn.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status: expect 400, got: %d", tt.name, resp.StatusCode)
+		}
+		if string(body) != tt.expect {
+			t.Errorf("%s: body: expect %q, got: %q", tt.name, tt.expect, body)
+		}
+	}
+}
+
+func TestStartupDelay(t *testing.T) {
+	c := &serverConfig{
+		addr:         "127.0.0.1:0",
+		headers:      http.Header{},
+		startupDelay: 300 * time.Millisecond,
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("OK")},
+		},
+	}
+	endpoints, err := listenAll(c)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(c)
+	start := time.Now()
+	go server.serveAll(endpoints, c.tls)
+	defer server.Close()
+
+	// the connection is queued until the server starts serving
+	resp, err := http.Get("http://" + endpoints[0].Addr().String())
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("response is expected to be delayed until startup, but took %s", elapsed)
+	}
+}
+
+func TestDualStack(t *testing.T) {
+	c := &serverConfig{
+		addr:      ":0",
+		headers:   http.Header{},
+		dualStack: true,
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("first")},
+			{statusCode: 200, body: []byte("second")},
+		},
+	}
+	endpoints, err := listenAll(c)
+	if err != nil {
+		t.Skipf("IPv6 is not available: %s", err)
+	}
+	if len(endpoints) != 2 {
+		t.Fatalf("expect 2 endpoints, got %d", len(endpoints))
+	}
+	server := newServer(c)
+	errCh := make(chan error, 1)
+	go func() { errCh <- server.serveAll(endpoints, c.tls) }()
+	defer server.Close()
+
+	port := strconv.Itoa(endpoints[0].Addr().(*net.TCPAddr).Port)
+	for i, host := range []string{"127.0.0.1", "::1"} {
+		resp, err := http.Get("http://" + net.JoinHostPort(host, port))
+		if err != nil {
+			t.Fatalf("http.Get %s failed: %s", host, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if expect := []string{"first", "second"}[i]; string(body) != expect {
+			t.Errorf("%s: expect %q, got %q", host, expect, body)
+		}
+	}
+
+	// the last response shuts down both listeners
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("expect ErrServerClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server was not shut down after all responses were served")
+	}
+	for _, e := range endpoints {
+		if conn, err := net.Dial("tcp", e.Addr().String()); err == nil {
+			conn.Close()
+			t.Errorf("%s is still listening", e.Addr())
+		}
+	}
+}
+
+func TestListenRetry(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %s", err)
+	}
+	addr := busy.Addr().String()
+
+	if l, err := listen(&serverConfig{addr: addr}); err == nil {
+		l.Close()
+		t.Fatal("listening on the busy port without retry is expected to fail")
+	}
+
+	// the port frees up during the retry window
+	time.AfterFunc(300*time.Millisecond, func() { busy.Close() })
+	start := time.Now()
+	l, err := listen(&serverConfig{addr: addr, listenRetry: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+	defer l.Close()
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("listen is expected to succeed after the port is freed, but took %s", elapsed)
+	}
+	if l.Addr().String() != addr {
+		t.Errorf("expect %s, got %s", addr, l.Addr())
+	}
+
+	// it gives up after the retry window
+	start = time.Now()
+	if l2, err := listen(&serverConfig{addr: addr, listenRetry: 200 * time.Millisecond}); err == nil {
+		l2.Close()
+		t.Fatal("listening on the busy port is expected to fail after the retry window")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("listen is expected to give up after the retry window, but took %s", elapsed)
+	}
+}
+
+func TestRefuseListener(t *testing.T) {
+	seed := int64(1)
+	c := &serverConfig{
+		addr:              "127.0.0.1:0",
+		headers:           http.Header{},
+		refuseProbability: 0.5,
+		seed:              &seed,
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("OK"), untilSignal: true},
+		},
+	}
+	endpoints, err := listenAll(c)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(c)
+	server.handler.logger.out = io.Discard
+	go server.serveAll(endpoints, c.tls)
+	defer server.Close()
+
+	const n = 20
+	refused := 0
+	for i := 0; i < n; i++ {
+		conn, err := net.Dial("tcp", endpoints[0].Addr().String())
+		if err != nil {
+			t.Fatalf("dial failed: %s", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"))
+		if _, err := conn.Read(make([]byte, 1)); err != nil {
+			refused++
+		}
+		conn.Close()
+	}
+
+	if refused == 0 || refused == n {
+		t.Errorf("some but not all connections are expected to be refused, but %d of %d were", refused, n)
+	}
+}
+
+func TestMaxConnsPerIP(t *testing.T) {
+	c := &serverConfig{
+		addr:          "127.0.0.1:0",
+		headers:       http.Header{},
+		maxConnsPerIP: 2,
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("OK"), untilSignal: true},
+		},
+	}
+	endpoints, err := listenAll(c)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(c)
+	server.handler.logger.out = io.Discard
+	go server.serveAll(endpoints, c.tls)
+	defer server.Close()
+
+	// served reports whether a request on a new connection is answered, leaving the connection open.
+	served := func() (net.Conn, bool) {
+		conn, err := net.Dial("tcp", endpoints[0].Addr().String())
+		if err != nil {
+			t.Fatalf("dial failed: %s", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			conn.Close()
+			return nil, false
+		}
+		resp.Body.Close()
+		return conn, true
+	}
+
+	open := []net.Conn{}
+	for i := 0; i < 2; i++ {
+		conn, ok := served()
+		if !ok {
+			t.Fatalf("connection %d is expected to be served", i)
+		}
+		defer conn.Close()
+		open = append(open, conn)
+	}
+	if _, ok := served(); ok {
+		t.Fatal("connection beyond the limit is expected to be refused")
+	}
+
+	// the count is released once the server notices the connection closed
+	open[0].Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, ok := served()
+		if ok {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection is expected to be served after another is closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
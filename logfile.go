@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// rotatingFile is a log file renamed to <path>.1 when it would exceed maxSize,
+// shifting the older ones to <path>.2 and so on.
+type rotatingFile struct {
+	mu   sync.Mutex
+	path string
+	// maxSize is the size in bytes the file is rotated at. Zero disables the rotation.
+	maxSize int64
+	f       *os.File
+	size    int64
+}
+
+// openRotatingFile opens the log file appending to it.
+func openRotatingFile(path string, maxSize int64) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would make it exceed maxSize.
+// A write larger than maxSize is not split.
+// If the rotation fails, p is still written to the file open and the error is returned.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	var rotateErr error
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		rotateErr = rf.rotate()
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// rotate renames the file to <path>.1 after shifting the older ones, and opens a new file at the path.
+// The file open is replaced only if a file is opened at the path, so that the logs are never
+// written to a closed file.
+func (rf *rotatingFile) rotate() error {
+	err := rf.shift()
+	if err != nil {
+		// the file may have been removed, so open the path again to write to a file still found
+		if openErr := rf.reopen(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		return err
+	}
+	return rf.reopen()
+}
+
+// shift renames the file and the rotated ones to the next suffixes.
+func (rf *rotatingFile) shift() error {
+	last := 1
+	for {
+		_, err := os.Stat(fmt.Sprintf("%s.%d", rf.path, last))
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		last++
+	}
+	for i := last; i > 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", rf.path, i-1), fmt.Sprintf("%s.%d", rf.path, i)); err != nil {
+			return err
+		}
+	}
+	return os.Rename(rf.path, rf.path+".1")
+}
+
+// reopen opens the path and closes the file open before if it succeeds.
+func (rf *rotatingFile) reopen() error {
+	old := rf.f
+	if err := rf.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mock.log")
+	f, err := openRotatingFile(path, 100)
+	if err != nil {
+		t.Fatalf("openRotatingFile failed: %s", err)
+	}
+	l := &logger{out: f}
+
+	lines := []string{}
+	for i := 0; i < 10; i++ {
+		line := fmt.Sprintf("log line %02d %s", i, strings.Repeat("x", 16))
+		lines = append(lines, line)
+		l.log(l.stdout(), line)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	// the oldest lines are in the file of the largest suffix
+	files := []string{path + ".3", path + ".2", path + ".1", path}
+	logged := ""
+	for _, name := range files {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("rotated file is expected to exist: %s", err)
+		}
+		if len(b) > 100 {
+			t.Errorf("%s exceeds the max size: %d bytes", filepath.Base(name), len(b))
+		}
+		logged += string(b)
+	}
+	if _, err := os.Stat(path + ".4"); !os.IsNotExist(err) {
+		t.Errorf("unexpected rotated file: %v", err)
+	}
+	if expect := strings.Join(lines, "\n") + "\n"; logged != expect {
+		t.Errorf("logs are lost or reordered by the rotation:\nexpect %q\ngot    %q", expect, logged)
+	}
+
+	// reopening appends to the existing file
+	f, err = openRotatingFile(path, 100)
+	if err != nil {
+		t.Fatalf("openRotatingFile failed: %s", err)
+	}
+	defer f.Close()
+	if f.size == 0 {
+		t.Error("size of the existing file is expected to be counted")
+	}
+}
+
+func TestRotatingFileRenameFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mock.log")
+	f, err := openRotatingFile(path, 12)
+	if err != nil {
+		t.Fatalf("openRotatingFile failed: %s", err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("first\n")); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	// the file removed under the writer cannot be renamed
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Remove failed: %s", err)
+	}
+	if _, err := f.Write([]byte("second\n")); err == nil {
+		t.Error("the rotation failure is expected to be returned")
+	}
+	if _, err := f.Write([]byte("3\n")); err != nil {
+		t.Errorf("writes after the rotation failure are expected to succeed: %s", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("the file is expected to be opened again: %s", err)
+	}
+	if string(b) != "second\n3\n" {
+		t.Errorf("logs after the failure: expect %q, got %q", "second\n3\n", b)
+	}
+}
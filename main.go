@@ -6,20 +6,137 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 )
 
 var usageFormat = `Usage: %s [GROBAL OPTIONS] <status> <body> [RESPONSE OPTIONS] [<status> <body> [RESPONSE OPTIONS]]...
+<body> can be omitted if --body or --message-file is given, or with --allow-empty-body if options or nothing follow.
+All responses can be omitted with --openapi.
 GROBAL OPTIONS:
   -c, --cert <cert file> Certificate file
   -H, --header <header> Add header to all responses
   -k, --key <key file> Private key file
   -p, --port <port> Port to listen (default: 8080)
+      --accept-delay <duration> Delay serving each accepted connection by <duration>
+      --admin-path <path> Serve an HTML page of the sequence at <path>, with buttons for --control-path if given
+      --allow-empty-body Allow omitting <body> for an empty body when options or nothing follow <status> (e.g. 200 -H X-A:1)
+      --alpn <protocol>[,<protocol>]... Protocols negotiated by TLS ALPN (e.g. http/1.1 disables h2)
+      --announce-json Print the scheme, address and port as JSON once listening
+      --bad-request-body <body> Respond with <body> to requests net/http rejects as malformed (plain HTTP/1.x only)
+      --body-affix-file Treat <prefix> and <suffix> as file paths and read them from the files
+      --body-prefix <prefix> Prepend <prefix> to all response bodies
+      --body-suffix <suffix> Append <suffix> to all response bodies
+      --client-ca <file> Verify client certificates against the CAs in <file> if clients send one
+      --connect-status <status> Answer CONNECT requests with <status> (e.g. 405) without serving responses of the sequence
+      --connect-tunnel Relay CONNECT requests to the requested host as a plain TCP tunnel instead
+      --continue-delay <duration> Delay the 100 Continue to requests with "Expect: 100-continue" by <duration> before reading the body
+      --control-path <path> Control the sequence by POST {"action":"<action>"} to <path> (reset, skip, pause or resume)
+      --count-header <name> Serve the N-th response to the request with N in header <name>, which must count up from 1 by one, and respond 409 otherwise
+      --crash-on-request <num> Exit abruptly with status 1 on the <num>-th request (for crash testing)
+      --delay-from-header <name> Delay each response by the duration in request header <name> (e.g. 2s)
+      --delay-ramp <duration> Increase the delay of responses by <duration> per request served before; rejected requests do not count
+      --dual-stack Listen on IPv4 and IPv6 with separate listeners regardless of the system default
+      --echo-client-cert Add the subject, issuer and serial of the verified client certificate as X-Client-Cert-* headers
+      --enable-trace Echo TRACE requests as message/http without serving responses of the sequence
+      --gzip-level <level> Compression level of --gzip, 1 to 9 or -1 (default: -1)
+      --gzip-min-size <bytes> Send bodies smaller than <bytes> uncompressed even with --gzip
+      --hash-select <attribute> Serve the response picked by the hash of "path", "remote-addr" or "header:<name>" of requests without advancing the sequence
+      --honor-method-override Serve requests as the method in X-HTTP-Method-Override, such as for --method and --method-body
+      --https-port <port> Also serve TLS on <port>; both ports advance the same sequence of responses
+      --idle-shutdown <duration> Shut down if no request arrives for <duration>
+      --ignore-favicon Respond 204 to /favicon.ico without logging it or serving responses of the sequence
+      --index-header <name> Serve the response at the 0-based index in header <name> out of sequence
+      --jitter <duration> Add a random delay of up to <duration> to each response, reproducible with --seed
+      --jitter-by-path Derive the --jitter delay from the request path and --seed so that the same path always gets the same delay
+      --listen-retry <duration> Retry binding the port for up to <duration> while it is in use, except on Plan 9
+      --log-curl Also log each request as a curl command reproducing it
+      --log-file <file> Append logs to <file> instead of stdout and stderr
+      --log-max-size <bytes> Rotate --log-file to <file>.1, shifting older ones to <file>.2 and so on, when it would exceed <bytes>
+      --log-summary Log the method, path, Host, User-Agent and Content-Type of requests instead of dumping them
+      --loop Serve the sequence from the first response again after the last, forever, instead of shutting down
+      --max-conns-per-ip <num> Close connections from a remote IP already having <num> open before serving them
+      --max-requests-per-conn <num> Close each connection after serving <num> requests on it (HTTP/1.x only)
+      --max-runtime <duration> Shut down after running for <duration> however many requests are served. Connections still active 1s later are closed
+      --merge-headers Join multiple values of a header with ", " into a single line
+      --no-shutdown Keep serving the last response after the sequence instead of shutting down. Stop the server with Ctrl-C
+      --not-found-template <template> Respond 404 with the body rendered from <template> when no response is left
+      --openapi <file> Serve the example responses of the operations in the OpenAPI JSON <file> in turn, out of the sequence
+      --order <order> Serve responses in "sequential", "reverse" or "shuffle" order (default: sequential)
+      --prefer-async Answer requests with "Prefer: respond-async" by 202 and serve their responses at the Location
+      --print-plan Print the table of responses in the order they are served to stderr before serving
+      --rate-limit <count>/<interval> Respond 429 to requests exceeding the rate (e.g. 10/s, 5/500ms)
+      --read-buffer <bytes> Set the socket receive buffer size of accepted connections
+      --reflect-headers <prefix> Copy each request header to the response with the name prefixed by <prefix> (e.g. X-Reflect-)
+      --refuse-probability <0-1> Close accepted connections at the probability before any HTTP exchange
+      --request-quota <num> Respond 429 to all requests after <num> requests until reset by --control-path
+      --request-read-timeout <duration> Respond 408 if reading a request body takes longer than <duration>
+      --response-delay-file <file> Delay the N-th response by the duration on the N-th line of <file>
+      --round-robin-path Serve the responses with the same --always path in turn, repeating, instead of only the last one
+      --seed <num> Seed random values such as --random-body and --order shuffle to make them reproducible
+      --server-header <value> Set the Server header of all responses to <value>
+      --shutdown-webhook <url> POST the number of requests and the reason of the shutdown to <url>
+      --startup-delay <duration> Wait <duration> after binding before serving any connection
+      --status-from-path <path> Respond the status in the segment after <path> (e.g. <path>/503) out of the sequence
+      --syslog Send logs to the local syslog server instead of stdout and stderr
+      --syslog-addr [<network>://]<host>:<port> Send logs to the syslog server instead (default network: udp)
+      --systemd Use the socket passed by systemd socket activation if any
+      --template-error-body <body> Respond 500 with <body> when a --template fails to render (default: Internal Server Error)
+      --time-format <format> Prefix logs with a timestamp in <format> ("rfc3339", "unix" or a Go layout)
+      --timing-log Log the time spent in the delay, in writing the body and in total of each request
+      --tls-handshake-delay <duration> Delay the TLS handshake of each connection by <duration>
+      --write-buffer <bytes> Set the socket send buffer size of accepted connections
 RESPONSE OPTIONS:
   -H, --header <header> Add header to the response
   -r, --repeat <positive num> Repeat the response
+      --active-from <time> Serve the response only from <time>, an offset from the startup like 30s or an RFC 3339 time
+      --active-until <time> Serve the response only until <time>, an offset from the startup like 30s or an RFC 3339 time
+      --also-send <count> Write <count> extra copies of the response on the connection after it (HTTP/1.x only)
+      --always <path> Serve the response to every request to <path> instead of as a part of the sequence
+      --attachment <filename> Serve the body as a download named <filename>
+      --bad-digest <algorithm> Set Content-Digest and Digest by "md5" or "sha256" not matching the body to test client integrity checks
+      --body <line> Add <line> to the body instead of <body>. Lines are joined with newlines
+      --body-encoding <encoding> Interpret <body> as "raw" or "escaped" with Go escape sequences like \n, \t and \x00 (default: raw)
       --body-file Treat <body> as a file path and read body from it
+      --body-limit <bytes> Read only the first <bytes> of the --body-file
+      --brotli Encode the body in the brotli format with "Content-Encoding: br". Literals are Huffman-coded without backward references
+      --chaos <behavior>:<weight>[,<behavior>:<weight>]... Apply normal, delay, drop (the connection) or truncate (the body to half) to each request at random by weight
+      --chaos-delay <duration> Delay of the --chaos delay behavior (default: 1s)
+      --cookie <cookie> Add Set-Cookie validating <cookie> like "name=value; Path=/; Max-Age=60; Secure"
+      --corrupt-length <bytes> Claim <bytes> more than sent in Content-Length to test client read timeouts
+      --crlf Convert the line endings of the body to CRLF
+      --empty-body-status <status> Status responded by --require-body (default: 400)
+      --grpc-web Frame the body as a gRPC-Web message followed by an OK status trailer
+      --grpc-web-text Same as --grpc-web but base64 encoded as application/grpc-web-text
+      --gzip Compress the body with gzip
+      --gzip-bomb <bytes> Send a small gzip stream of zeros decompressing to <bytes> instead of <body>, which must be empty. Clients without a decompression limit may exhaust their memory
+      --headers-json <json> Add headers from a JSON object of name to a value or an array of values
+      --lf Convert the line endings of the body to LF
+      --match-cookie <name>=<value> Serve the response only to requests with the cookie; others get later responses
+      --match-language <tag> Serve the response only to requests accepting <tag> by Accept-Language, preferring the best match
+      --message-file <file> Serve the status, headers and body of the HTTP response message captured in <file> instead of <status> and <body>
+      --method <method> Serve the response only to requests with <method>, case-insensitively, or the method in X-HTTP-Method-Override with --honor-method-override. HEAD requests match GET. Responses without it match any method
+      --method-body <method>:<body>[,<method>:<body>]... Use <body> for requests with <method>
+      --multipart Serve multipart/form-data of the --part options, where <body> must be empty
+      --ndjson Stream each line of the body as a JSON value of application/x-ndjson
+      --ndjson-interval <duration> Wait <duration> between the lines of --ndjson
+      --obs-fold <header> Write <header> folded onto continuation lines by the obsolete line folding (HTTP/1.x only)
+      --pad-headers <size> Add a dummy X-Pad header with a <size>-byte value to test client header limits
+      --part <name>:<content-type>:<body> Add a part to --multipart. <content-type> can be empty
+      --random-body <size> Use <size> random bytes as the body instead of <body>
+      --redirect-permanent <url> Redirect to <url> by 308 preserving the method instead of <status code>
+      --redirect-temporary <url> Redirect to <url> by 307 preserving the method instead of <status code>
+      --reload-file Read the --body-file on every request instead of once at startup
+      --require-body Respond --empty-body-status to requests without a body, leaving the response for a later request with one
+      --require-tls Serve the response only over TLS; plaintext requests get 426 if nothing else is left
+      --sse Stream each line of the body as the data of a server-sent event of text/event-stream
+      --sse-interval <duration> Wait <duration> between the events of --sse
+      --sse-keepalive <duration> Write a ":" comment every <duration> while waiting --sse-interval between the events of --sse
+      --template Render <body> as a Go template of the request with .Index (ordinal) and .Remaining (responses left)
+      --trailer-checksum <algorithm> Send the body chunked with its Content-Digest by "md5" or "sha256" in the trailer
       --trim-newline Remove all leading and traling newline from body
+      --until-signal Keep serving the response without shutting down once reached (must be the last)
 `
 var usage = fmt.Sprintf(usageFormat, filepath.Base(os.Args[0]))
 
@@ -35,14 +152,62 @@ func main() {
 		os.Exit(1)
 	}
 
+	endpoints, err := listenAll(sc)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if sc.announceJSON {
+		for _, e := range endpoints {
+			if err := announce(os.Stdout, e.Addr(), e.scheme()); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+
 	server := newServer(sc)
 
-	if sc.tls != nil {
-		err = server.ListenAndServeTLS(sc.tls.certFile, sc.tls.keyFile)
-	} else {
-		err = server.ListenAndServe()
+	if sc.printPlan {
+		if err := server.handler.printPlan(os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if sc.syslog != nil {
+		w, err := openSyslog(sc.syslog)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		server.handler.logger.out = w
+		server.handler.logger.errOut = w
 	}
+	if sc.logFile != "" {
+		f, err := openRotatingFile(sc.logFile, sc.logMaxSize)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		server.handler.logger.out = f
+		server.handler.logger.errOut = f
+	}
+
+	server.handler.warnIgnoredBodies()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		// a second signal terminates the process immediately
+		signal.Stop(sigCh)
+		server.shutdown("signal")
+	}()
 
+	err = server.serveAll(endpoints, sc.tls)
 	if !errors.Is(err, http.ErrServerClosed) {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// messageHopHeaders are the headers of a message file describing its framing on the original
+// connection, which are dropped since the response is framed anew when served.
+var messageHopHeaders = []string{"Connection", "Keep-Alive", "Content-Length", "Transfer-Encoding"}
+
+// loadMessageFile reads the status, the headers and the body of the HTTP/1.x response message
+// in the file. A chunked body is decoded; other encodings such as gzip are kept as captured.
+func loadMessageFile(file string) (int, http.Header, []byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer f.Close()
+
+	resp, err := http.ReadResponse(bufio.NewReader(f), nil)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid message file %s: %w", file, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 100 || resp.StatusCode > 599 {
+		return 0, nil, nil, fmt.Errorf("invalid message file %s: status must be between 100 and 599: %d", file, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid message file %s: %w", file, err)
+	}
+
+	for _, name := range messageHopHeaders {
+		resp.Header.Del(name)
+	}
+	return resp.StatusCode, resp.Header, body, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// multipartPart is a part of a multipart/form-data body.
+type multipartPart struct {
+	name        string
+	contentType string
+	body        []byte
+}
+
+// parseMultipartPart parses <name>:<content-type>:<body>. The content type can be empty.
+func parseMultipartPart(s string) (multipartPart, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return multipartPart{}, fmt.Errorf("invalid part: %q", s)
+	}
+	if parts[1] != "" {
+		if _, _, err := mime.ParseMediaType(parts[1]); err != nil {
+			return multipartPart{}, fmt.Errorf("invalid part content type: %q", parts[1])
+		}
+	}
+	return multipartPart{name: parts[0], contentType: parts[1], body: []byte(parts[2])}, nil
+}
+
+// multipartBody assembles the parts into a multipart/form-data body
+// and returns it with its Content-Type including the boundary.
+func multipartBody(parts []multipartPart) ([]byte, string) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for _, p := range parts {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": p.name}))
+		if p.contentType != "" {
+			h.Set("Content-Type", p.contentType)
+		}
+		// writing to bytes.Buffer never fails
+		pw, _ := w.CreatePart(h)
+		pw.Write(p.body)
+	}
+	w.Close()
+	return buf.Bytes(), w.FormDataContentType()
+}
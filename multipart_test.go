@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServerMultipart(t *testing.T) {
+	sc, err := parseArgs([]string{
+		"200", "", "--multipart",
+		"--part", "greeting::hello",
+		"--part", "data:application/json:{\"a\":1}",
+		"--part", "time:text/plain:12:00",
+	})
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	h := newHandler(sc, func() {})
+	h.logger.out = io.Discard
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("Content-Type is not multipart/form-data: %q", resp.Header.Get("Content-Type"))
+	}
+
+	expect := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{name: "greeting", contentType: "", body: "hello"},
+		{name: "data", contentType: "application/json", body: `{"a":1}`},
+		{name: "time", contentType: "text/plain", body: "12:00"},
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for i, e := range expect {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("reading part %d failed: %s", i, err)
+		}
+		body, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatalf("reading body of part %d failed: %s", i, err)
+		}
+		if p.FormName() != e.name || p.Header.Get("Content-Type") != e.contentType || string(body) != e.body {
+			t.Errorf("part %d: expect %s %q %q, got: %s %q %q", i, e.name, e.contentType, e.body, p.FormName(), p.Header.Get("Content-Type"), body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expect %d parts, but got more: %v", len(expect), err)
+	}
+}
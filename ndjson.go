@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonLines returns the non-empty lines of the body.
+func ndjsonLines(body []byte) [][]byte {
+	lines := [][]byte{}
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// validateNDJSON returns an error if any line of the body is not a JSON value.
+func validateNDJSON(body []byte) error {
+	for i, line := range ndjsonLines(body) {
+		if !json.Valid(line) {
+			return fmt.Errorf("ndjson line %d is not valid JSON: %q", i+1, line)
+		}
+	}
+	return nil
+}
+
+// streamNDJSON writes the lines of the body one by one, flushing each of them
+// and waiting interval between them.
+func (h *handler) streamNDJSON(w http.ResponseWriter, r *http.Request, body []byte, interval time.Duration) {
+	rc := http.NewResponseController(w)
+	for i, line := range ndjsonLines(body) {
+		if i > 0 && interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		// the full slice expression copies the line not to overwrite the shared body
+		if _, err := w.Write(append(line[:len(line):len(line)], '\n')); err != nil {
+			h.logWriteError(err)
+			return
+		}
+		rc.Flush()
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerNDJSON(t *testing.T) {
+	sc, err := parseArgs([]string{
+		"200", "{\"n\":1}\n{\"n\":2}\n\n{\"n\":3}\n", "--ndjson", "--ndjson-interval", "100ms",
+	})
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	h := newHandler(sc, func() {})
+	h.logger.out = io.Discard
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type: expect application/x-ndjson, but got %q", ct)
+	}
+	if resp.ContentLength != -1 {
+		t.Errorf("Content-Length is expected to be unknown, but got %d", resp.ContentLength)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	var first time.Time
+	for i := 1; i <= 3; i++ {
+		var v struct{ N int }
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("decoding line %d failed: %s", i, err)
+		}
+		if v.N != i {
+			t.Errorf("line %d: expect n=%d, but got %d", i, i, v.N)
+		}
+		if i == 1 {
+			first = time.Now()
+		}
+	}
+	if elapsed := time.Since(first); elapsed < 200*time.Millisecond {
+		t.Errorf("lines are expected to be streamed at the interval, but took %s", elapsed)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		t.Errorf("stream is expected to end after 3 lines, but got: %v", err)
+	}
+}
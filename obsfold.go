@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// foldHeaderValue returns the header field with the words of the value on continuation lines
+// of the obsolete line folding of RFC 7230. The first word stays on the first line if there are
+// more, so that the field is folded at least once.
+func foldHeaderValue(name, value string) string {
+	words := strings.Fields(value)
+	if len(words) < 2 {
+		return name + ":\r\n " + value
+	}
+	return name + ": " + strings.Join(words, "\r\n ")
+}
+
+// writeObsFold writes the response with its obsFold headers folded as a raw HTTP/1.1 response
+// since net/http never folds headers, and closes the connection.
+// The response is written normally over HTTP/2, which has no folding.
+func (h *handler) writeObsFold(w http.ResponseWriter, r *http.Request, resp *response, body []byte) {
+	conn, bufrw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Failed to fold headers: %v", err))
+		h.writeResponse(w, r, resp, body)
+		return
+	}
+	defer conn.Close()
+
+	header := w.Header().Clone()
+	copyHeader(header, resp.headers)
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("Connection", "close")
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "HTTP/1.1 %03d %s\r\n", resp.statusCode, http.StatusText(resp.statusCode))
+	header.Write(b)
+	names := make([]string, 0, len(resp.obsFold))
+	for name := range resp.obsFold {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range resp.obsFold[name] {
+			b.WriteString(foldHeaderValue(name, v) + "\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+	if r.Method != http.MethodHead {
+		b.Write(body)
+	}
+
+	if _, err := bufrw.Write(b.Bytes()); err != nil {
+		h.logWriteError(err)
+		return
+	}
+	if err := bufrw.Flush(); err != nil {
+		h.logWriteError(err)
+	}
+}
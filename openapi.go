@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// openAPIMethods are the operations of an OpenAPI path item.
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openAPIRoute is the example responses of an operation of an OpenAPI spec.
+type openAPIRoute struct {
+	// path may have templated segments like {id}.
+	path      string
+	method    string
+	responses []*responseConfig
+}
+
+// openAPIOperation serves the example responses of a route in turn, repeating them.
+type openAPIOperation struct {
+	segments  []string
+	method    string
+	responses []*response
+	// next is the index of the response served next. It is guarded by handler.mu.
+	next int
+}
+
+// openAPIMediaType is the part of an OpenAPI media type object holding examples.
+type openAPIMediaType struct {
+	Example  json.RawMessage `json:"example"`
+	Examples map[string]struct {
+		Value json.RawMessage `json:"value"`
+	} `json:"examples"`
+}
+
+type openAPIOperationObject struct {
+	Responses map[string]struct {
+		Content map[string]openAPIMediaType `json:"content"`
+	} `json:"responses"`
+}
+
+// loadOpenAPI loads the example responses of the operations in the OpenAPI spec in JSON.
+// Only inline examples are used; references are not resolved and nothing is validated.
+// Responses without content are served with an empty body.
+func loadOpenAPI(file string) ([]*openAPIRoute, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return nil, fmt.Errorf("invalid openapi: %w", err)
+	}
+
+	routes := []*openAPIRoute{}
+	for path, item := range spec.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid openapi: path must start with /: %q", path)
+		}
+		for _, method := range openAPIMethods {
+			raw, ok := item[method]
+			if !ok {
+				continue
+			}
+			var op openAPIOperationObject
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, fmt.Errorf("invalid openapi: %s %s: %w", strings.ToUpper(method), path, err)
+			}
+			resps := openAPIExamples(op)
+			if len(resps) == 0 {
+				continue
+			}
+			routes = append(routes, &openAPIRoute{path: path, method: strings.ToUpper(method), responses: resps})
+		}
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("invalid openapi: no example response in %s", file)
+	}
+
+	// literal segments take precedence over templated ones
+	sort.Slice(routes, func(i, j int) bool {
+		ti, tj := strings.Count(routes[i].path, "{"), strings.Count(routes[j].path, "{")
+		if ti != tj {
+			return ti < tj
+		}
+		if routes[i].path != routes[j].path {
+			return routes[i].path < routes[j].path
+		}
+		return routes[i].method < routes[j].method
+	})
+	return routes, nil
+}
+
+// openAPIExamples returns the example responses of the operation ordered by status,
+// media type and example name.
+func openAPIExamples(op openAPIOperationObject) []*responseConfig {
+	statuses := []int{}
+	for s := range op.Responses {
+		// ranges such as 2XX and default have no status to respond
+		if code, err := strconv.Atoi(s); err == nil && code >= 100 && code <= 599 {
+			statuses = append(statuses, code)
+		}
+	}
+	sort.Ints(statuses)
+
+	resps := []*responseConfig{}
+	for _, code := range statuses {
+		content := op.Responses[strconv.Itoa(code)].Content
+		if len(content) == 0 {
+			resps = append(resps, &responseConfig{statusCode: code, body: []byte{}, headers: http.Header{}})
+			continue
+		}
+		types := make([]string, 0, len(content))
+		for t := range content {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			for _, value := range content[t].values() {
+				resps = append(resps, &responseConfig{
+					statusCode: code,
+					body:       openAPIBody(t, value),
+					headers:    http.Header{"Content-Type": {t}},
+				})
+			}
+		}
+	}
+	return resps
+}
+
+// values returns the example and the values of the examples ordered by name.
+func (m openAPIMediaType) values() []json.RawMessage {
+	values := []json.RawMessage{}
+	if m.Example != nil {
+		values = append(values, m.Example)
+	}
+	names := make([]string, 0, len(m.Examples))
+	for name := range m.Examples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if v := m.Examples[name].Value; v != nil {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// openAPIBody returns the body of the example value in the media type.
+// Strings are served as is unless the media type is JSON.
+func openAPIBody(mediaType string, value json.RawMessage) []byte {
+	var s string
+	if !strings.Contains(mediaType, "json") && json.Unmarshal(value, &s) == nil {
+		return []byte(s)
+	}
+	b := &bytes.Buffer{}
+	if err := json.Compact(b, value); err != nil {
+		return value
+	}
+	return b.Bytes()
+}
+
+// matches reports whether the request is to the operation.
+func (o *openAPIOperation) matches(r *http.Request) bool {
+	if r.Method != o.method {
+		return false
+	}
+	segments := strings.Split(r.URL.Path, "/")
+	if len(segments) != len(o.segments) {
+		return false
+	}
+	for i, s := range o.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if segments[i] == "" {
+				return false
+			}
+		} else if segments[i] != s {
+			return false
+		}
+	}
+	return true
+}
+
+// openAPIResponse returns the next example response of the operation the request is to,
+// or nil if the request is to none of the operations.
+func (h *handler) openAPIResponse(r *http.Request) *response {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, o := range h.openAPI {
+		if o.matches(r) {
+			resp := o.responses[o.next]
+			o.next = (o.next + 1) % len(o.responses)
+			return resp
+		}
+	}
+	return nil
+}
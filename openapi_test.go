@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testOpenAPISpec = `{
+  "openapi": "3.0.0",
+  "paths": {
+    "/pets": {
+      "get": {
+        "responses": {
+          "200": {"content": {"application/json": {"example": [ {"id": 1}, {"id": 2} ]}}}
+        }
+      },
+      "post": {
+        "responses": {
+          "default": {"content": {"application/json": {"example": {"error": "unexpected"}}}},
+          "201": {}
+        }
+      }
+    },
+    "/pets/{id}": {
+      "parameters": [{"name": "id", "in": "path"}],
+      "get": {
+        "responses": {
+          "404": {"content": {"text/plain": {"example": "no such pet"}}},
+          "200": {"content": {"application/json": {"examples": {
+            "b": {"value": {"id": 1, "name": "b"}},
+            "a": {"value": {"id": 1, "name": "a"}},
+            "ref": {"$ref": "#/components/examples/pet"}
+          }}}}
+        }
+      }
+    },
+    "/pets/mine": {
+      "get": {
+        "responses": {
+          "200": {"content": {"text/plain": {"example": "mine"}}}
+        }
+      }
+    }
+  }
+}`
+
+func writeOpenAPISpec(t *testing.T, spec string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "openapi.json")
+	if err := os.WriteFile(file, []byte(spec), 0o644); err != nil {
+		t.Fatalf("writing spec failed: %s", err)
+	}
+	return file
+}
+
+func TestHandler_ServeHTTPOpenAPI(t *testing.T) {
+	sc, err := parseArgs([]string{"--openapi", writeOpenAPISpec(t, testOpenAPISpec), "200", "sequence"})
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	handler := newHandler(sc, func() {})
+	handler.logger.out = io.Discard
+
+	steps := []struct {
+		method            string
+		path              string
+		expectCode        int
+		expectBody        string
+		expectContentType string
+	}{
+		{method: "GET", path: "/pets", expectCode: 200, expectBody: `[{"id":1},{"id":2}]`, expectContentType: "application/json"},
+		{method: "POST", path: "/pets", expectCode: 201, expectBody: ""},
+		{method: "GET", path: "/pets/1", expectCode: 200, expectBody: `{"id":1,"name":"a"}`, expectContentType: "application/json"},
+		{method: "GET", path: "/pets/2", expectCode: 200, expectBody: `{"id":1,"name":"b"}`, expectContentType: "application/json"},
+		{method: "GET", path: "/pets/3", expectCode: 404, expectBody: "no such pet", expectContentType: "text/plain"},
+		// the examples are repeated
+		{method: "GET", path: "/pets/1", expectCode: 200, expectBody: `{"id":1,"name":"a"}`, expectContentType: "application/json"},
+		// literal segments take precedence over templated ones
+		{method: "GET", path: "/pets/mine", expectCode: 200, expectBody: "mine", expectContentType: "text/plain"},
+		// other requests are served the sequence
+		{method: "DELETE", path: "/pets/1", expectCode: 200, expectBody: "sequence"},
+	}
+
+	for i, s := range steps {
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, httptest.NewRequest(s.method, s.path, nil))
+
+		if w.Code != s.expectCode || w.Body.String() != s.expectBody {
+			t.Errorf("step %d %s %s: expect %d %q, got: %d %q", i, s.method, s.path, s.expectCode, s.expectBody, w.Code, w.Body.String())
+		}
+		if s.expectContentType != "" && w.Header().Get("Content-Type") != s.expectContentType {
+			t.Errorf("step %d: Content-Type: expect %q, got %q", i, s.expectContentType, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestParseArgsOpenAPI(t *testing.T) {
+	// the responses can be omitted
+	sc, err := parseArgs([]string{"--openapi", writeOpenAPISpec(t, testOpenAPISpec)})
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	if len(sc.responses) != 0 || len(sc.openAPI) != 4 {
+		t.Errorf("expect 0 responses and 4 routes, got %d and %d", len(sc.responses), len(sc.openAPI))
+	}
+
+	cases := []struct {
+		name string
+		spec string
+	}{
+		{name: "InvalidJSON", spec: `{"paths":`},
+		{name: "RelativePath", spec: `{"paths": {"pets": {"get": {"responses": {"200": {}}}}}}`},
+		{name: "NoExample", spec: `{"paths": {"/pets": {"get": {"responses": {"200": {"content": {"application/json": {}}}}}}}}`},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := parseArgs([]string{"--openapi", writeOpenAPISpec(t, c.spec)}); err == nil {
+				t.Error("error was expected but got nil")
+			}
+		})
+	}
+}
@@ -3,15 +3,24 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
+	"mime"
 	"net/http"
 	"net/textproto"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -39,18 +48,54 @@ var (
 	loadBodyFile loadBody = func(s string) ([]byte, error) { return os.ReadFile(s) }
 )
 
+// loadBodyFileLimit reads at most limit bytes from the beginning of the file.
+func loadBodyFileLimit(limit int) loadBody {
+	return func(s string) ([]byte, error) {
+		f, err := os.Open(s)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, int64(limit)))
+	}
+}
+
 func parseArgs(args []string) (*serverConfig, error) {
 	server, rest, err := parseGrobalOptions(args)
 	if err != nil {
 		return nil, err
 	}
 
-	resps, err := parseResponsesPart(rest)
-	if err != nil {
-		return nil, err
+	// the responses can be omitted if the OpenAPI examples are served instead
+	resps := []*responseConfig{}
+	if len(rest) > 0 || server.openAPI == nil {
+		resps, err = parseResponsesPart(rest, randomSource(server.seed), server.allowEmptyBody)
+		if err != nil {
+			return nil, err
+		}
 	}
 	server.responses = resps
 
+	if server.responseDelays != nil {
+		n := 0
+		for _, r := range resps {
+			if r.alwaysPath == "" {
+				n++
+			}
+		}
+		if len(server.responseDelays) != n {
+			return nil, fmt.Errorf("response delay file has %d delays but %d responses are configured", len(server.responseDelays), n)
+		}
+	}
+
+	if server.tls == nil {
+		for _, r := range resps {
+			if r.requireTLS {
+				return nil, errors.New("require-tls requires cert and key options")
+			}
+		}
+	}
+
 	return server, nil
 }
 
@@ -60,18 +105,173 @@ func parseGrobalOptions(args []string) (*serverConfig, []string, error) {
 	f.SetOutput(io.Discard)
 
 	optPort := defaultPort
+	optHTTPSPort := 0
+	optHandshakeDelay := time.Duration(0)
+	optALPN := ""
+	optClientCA := ""
+	optEchoClientCert := false
+	optRequestQuota := 0
+	optRefuseProbability := 0.0
+	optMaxConnsPerIP := 0
+	optDelayFromHeader := ""
+	optReadBuffer := 0
+	optWriteBuffer := 0
+	optLogCurl := false
+	optEnableTrace := false
+	optStatusFromPath := ""
+	optPreferAsync := false
+	optAllowEmptyBody := false
+	optReflectHeaders := ""
+	optTemplateErrorBody := ""
+	optDualStack := false
+	optIgnoreFavicon := false
+	optNoShutdown := false
+	optLoop := false
+	optIdleShutdown := time.Duration(0)
+	optMaxRuntime := time.Duration(0)
+	optContinueDelay := time.Duration(0)
+	optMaxRequestsPerConn := 0
+	optListenRetry := time.Duration(0)
+	optOpenAPI := ""
+	optRoundRobinPath := false
+	optTimingLog := false
+	optConnectStatus := 0
+	optConnectTunnel := false
+	optHashSelect := ""
+	optCountHeader := ""
+	optLogFile := ""
+	optLogMaxSize := int64(0)
+	optAdminPath := ""
 	optHeaders := optStringArray([]string{})
 	optCertFile := ""
 	optCertKeyFile := ""
+	optSystemd := false
+	optDelayFile := ""
+	optBodyPrefix := ""
+	optBodySuffix := ""
+	loadAffix := loadBodyRaw
+	optIndexHeader := ""
+	optCrashOnRequest := 0
+	optTimeFormat := ""
+	optDelayRamp := time.Duration(0)
+	optJitter := time.Duration(0)
+	optJitterByPath := false
+	optMergeHeaders := false
+	optAnnounceJSON := false
+	optNotFoundTemplate := ""
+	var optRateLimit *rateLimitConfig
+	optShutdownWebhook := ""
+	optGzipLevel := 0
+	optGzipMinSize := 0
+	var optSeed *int64
+	optLogSummary := false
+	optAcceptDelay := time.Duration(0)
+	optBadRequestBody := ""
+	optControlPath := ""
+	optOrder := "sequential"
+	optRequestReadTimeout := time.Duration(0)
+	optServerHeader := ""
+	optSyslog := false
+	optSyslogAddr := ""
+	optHonorMethodOverride := false
+	optPrintPlan := false
+	optStartupDelay := time.Duration(0)
 
 	f.IntVar(&optPort, "p", defaultPort, "")
 	f.IntVar(&optPort, "port", defaultPort, "")
+	f.IntVar(&optHTTPSPort, "https-port", 0, "")
+	f.DurationVar(&optHandshakeDelay, "tls-handshake-delay", 0, "")
+	f.StringVar(&optALPN, "alpn", "", "")
+	f.StringVar(&optClientCA, "client-ca", "", "")
+	f.BoolVar(&optEchoClientCert, "echo-client-cert", false, "")
+	f.IntVar(&optRequestQuota, "request-quota", 0, "")
+	f.Float64Var(&optRefuseProbability, "refuse-probability", 0, "")
+	f.IntVar(&optMaxConnsPerIP, "max-conns-per-ip", 0, "")
+	f.StringVar(&optDelayFromHeader, "delay-from-header", "", "")
+	f.IntVar(&optReadBuffer, "read-buffer", 0, "")
+	f.IntVar(&optWriteBuffer, "write-buffer", 0, "")
+	f.BoolVar(&optLogCurl, "log-curl", false, "")
+	f.BoolVar(&optEnableTrace, "enable-trace", false, "")
+	f.StringVar(&optStatusFromPath, "status-from-path", "", "")
+	f.BoolVar(&optPreferAsync, "prefer-async", false, "")
+	f.BoolVar(&optAllowEmptyBody, "allow-empty-body", false, "")
+	f.StringVar(&optReflectHeaders, "reflect-headers", "", "")
+	f.StringVar(&optTemplateErrorBody, "template-error-body", "", "")
+	f.BoolVar(&optDualStack, "dual-stack", false, "")
+	f.BoolVar(&optIgnoreFavicon, "ignore-favicon", false, "")
+	f.BoolVar(&optNoShutdown, "no-shutdown", false, "")
+	f.BoolVar(&optLoop, "loop", false, "")
+	f.DurationVar(&optIdleShutdown, "idle-shutdown", 0, "")
+	f.DurationVar(&optMaxRuntime, "max-runtime", 0, "")
+	f.DurationVar(&optContinueDelay, "continue-delay", 0, "")
+	f.IntVar(&optMaxRequestsPerConn, "max-requests-per-conn", 0, "")
+	f.DurationVar(&optListenRetry, "listen-retry", 0, "")
+	f.StringVar(&optOpenAPI, "openapi", "", "")
+	f.BoolVar(&optRoundRobinPath, "round-robin-path", false, "")
+	f.BoolVar(&optTimingLog, "timing-log", false, "")
+	f.IntVar(&optConnectStatus, "connect-status", 0, "")
+	f.BoolVar(&optConnectTunnel, "connect-tunnel", false, "")
+	f.StringVar(&optHashSelect, "hash-select", "", "")
+	f.StringVar(&optCountHeader, "count-header", "", "")
+	f.StringVar(&optLogFile, "log-file", "", "")
+	f.Int64Var(&optLogMaxSize, "log-max-size", 0, "")
+	f.StringVar(&optAdminPath, "admin-path", "", "")
 	f.Var(&optHeaders, "H", "")
 	f.Var(&optHeaders, "header", "")
 	f.StringVar(&optCertFile, "c", "", "")
 	f.StringVar(&optCertFile, "cert", "", "")
 	f.StringVar(&optCertKeyFile, "k", "", "")
 	f.StringVar(&optCertKeyFile, "key", "", "")
+	f.BoolVar(&optSystemd, "systemd", false, "")
+	f.StringVar(&optDelayFile, "response-delay-file", "", "")
+	f.StringVar(&optBodyPrefix, "body-prefix", "", "")
+	f.StringVar(&optBodySuffix, "body-suffix", "", "")
+	f.BoolFunc("body-affix-file", "", func(_ string) error { loadAffix = loadBodyFile; return nil })
+	f.StringVar(&optIndexHeader, "index-header", "", "")
+	f.IntVar(&optCrashOnRequest, "crash-on-request", 0, "")
+	f.StringVar(&optTimeFormat, "time-format", "", "")
+	f.DurationVar(&optDelayRamp, "delay-ramp", 0, "")
+	f.DurationVar(&optJitter, "jitter", 0, "")
+	f.BoolVar(&optJitterByPath, "jitter-by-path", false, "")
+	f.BoolVar(&optMergeHeaders, "merge-headers", false, "")
+	f.BoolVar(&optAnnounceJSON, "announce-json", false, "")
+	f.StringVar(&optNotFoundTemplate, "not-found-template", "", "")
+	f.Func("rate-limit", "", func(s string) (err error) {
+		optRateLimit, err = parseRateLimit(s)
+		return err
+	})
+	f.StringVar(&optShutdownWebhook, "shutdown-webhook", "", "")
+	f.BoolVar(&optLogSummary, "log-summary", false, "")
+	f.DurationVar(&optAcceptDelay, "accept-delay", 0, "")
+	f.StringVar(&optBadRequestBody, "bad-request-body", "", "")
+	f.StringVar(&optControlPath, "control-path", "", "")
+	f.StringVar(&optOrder, "order", "sequential", "")
+	f.DurationVar(&optRequestReadTimeout, "request-read-timeout", 0, "")
+	f.StringVar(&optServerHeader, "server-header", "", "")
+	f.BoolVar(&optSyslog, "syslog", false, "")
+	f.StringVar(&optSyslogAddr, "syslog-addr", "", "")
+	f.BoolVar(&optHonorMethodOverride, "honor-method-override", false, "")
+	f.BoolVar(&optPrintPlan, "print-plan", false, "")
+	f.DurationVar(&optStartupDelay, "startup-delay", 0, "")
+	f.Func("seed", "", func(s string) error {
+		seed, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		optSeed = &seed
+		return nil
+	})
+	f.IntVar(&optGzipMinSize, "gzip-min-size", 0, "")
+	f.Func("gzip-level", "", func(s string) (err error) {
+		optGzipLevel, err = strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		if !validGzipLevel(optGzipLevel) {
+			return errors.New("gzip-level must be -1 or between 1 and 9")
+		}
+		return nil
+	})
 
 	if err := f.Parse(args); err != nil {
 		return nil, nil, err
@@ -89,18 +289,354 @@ func parseGrobalOptions(args []string) (*serverConfig, []string, error) {
 		return nil, nil, errors.New("cert option is not set")
 	}
 
+	if optHandshakeDelay != 0 {
+		if tls == nil {
+			return nil, nil, errors.New("tls-handshake-delay requires cert and key options")
+		}
+		if optHandshakeDelay < 0 {
+			return nil, nil, errors.New("tls-handshake-delay must not be negative")
+		}
+		tls.handshakeDelay = optHandshakeDelay
+	}
+
+	if optALPN != "" {
+		if tls == nil {
+			return nil, nil, errors.New("alpn requires cert and key options")
+		}
+		tls.alpn = strings.Split(optALPN, ",")
+	}
+
+	if optClientCA != "" {
+		if tls == nil {
+			return nil, nil, errors.New("client-ca requires cert and key options")
+		}
+		pool, err := loadCertPool(optClientCA)
+		if err != nil {
+			return nil, nil, err
+		}
+		tls.clientCAs = pool
+	} else if optEchoClientCert {
+		return nil, nil, errors.New("echo-client-cert requires client-ca option")
+	}
+
+	httpsAddr := ""
+	if optHTTPSPort != 0 {
+		if tls == nil {
+			return nil, nil, errors.New("https-port requires cert and key options")
+		}
+		httpsAddr = fmt.Sprintf(":%d", optHTTPSPort)
+	}
+
+	if optDualStack && optSystemd {
+		return nil, nil, errors.New("dual-stack cannot be used with systemd")
+	}
+
 	headers, err := parseHeaders(optHeaders)
 	if err != nil {
 		return nil, nil, err
 	}
+	if optServerHeader != "" {
+		// merged as a global header so that responses can override it with -H
+		headers.Set("Server", optServerHeader)
+	}
+
+	if optCrashOnRequest < 0 {
+		return nil, nil, errors.New("crash-on-request must not be negative")
+	}
+
+	if optControlPath != "" && !strings.HasPrefix(optControlPath, "/") {
+		return nil, nil, errors.New("control-path must start with /")
+	}
+
+	if optAdminPath != "" {
+		if !strings.HasPrefix(optAdminPath, "/") {
+			return nil, nil, errors.New("admin-path must start with /")
+		}
+		if optAdminPath == optControlPath {
+			return nil, nil, errors.New("admin-path must differ from control-path")
+		}
+	}
+
+	if optStatusFromPath != "" && !strings.HasPrefix(optStatusFromPath, "/") {
+		return nil, nil, errors.New("status-from-path must start with /")
+	}
+
+	switch optOrder {
+	case "sequential":
+		optOrder = ""
+	case "reverse", "shuffle":
+	default:
+		return nil, nil, fmt.Errorf("order must be sequential, reverse or shuffle: %q", optOrder)
+	}
+
+	if optRequestReadTimeout < 0 {
+		return nil, nil, errors.New("request-read-timeout must not be negative")
+	}
+
+	if optReadBuffer < 0 || optWriteBuffer < 0 {
+		return nil, nil, errors.New("read-buffer and write-buffer must not be negative")
+	}
+
+	if math.IsNaN(optRefuseProbability) || optRefuseProbability < 0 || optRefuseProbability > 1 {
+		return nil, nil, errors.New("refuse-probability must be between 0 and 1")
+	}
+
+	if optMaxConnsPerIP < 0 {
+		return nil, nil, errors.New("max-conns-per-ip must not be negative")
+	}
+
+	if optRequestQuota < 0 {
+		return nil, nil, errors.New("request-quota must not be negative")
+	}
+
+	if optGzipMinSize < 0 {
+		return nil, nil, errors.New("gzip-min-size must not be negative")
+	}
+
+	var openAPI []*openAPIRoute
+	if optOpenAPI != "" {
+		openAPI, err = loadOpenAPI(optOpenAPI)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if optConnectStatus != 0 {
+		if optConnectTunnel {
+			return nil, nil, errors.New("connect-status cannot be used with connect-tunnel")
+		}
+		// informational statuses cannot be the final answer
+		if optConnectStatus < 200 || optConnectStatus > 599 {
+			return nil, nil, errors.New("connect-status must be between 200 and 599")
+		}
+	}
+
+	switch {
+	case optHashSelect == "", optHashSelect == "path", optHashSelect == "remote-addr":
+	case strings.HasPrefix(optHashSelect, "header:") && optHashSelect != "header:":
+	default:
+		return nil, nil, fmt.Errorf("hash-select must be path, remote-addr or header:<name>: %q", optHashSelect)
+	}
+
+	if optCountHeader != "" && (optIndexHeader != "" || optHashSelect != "") {
+		return nil, nil, errors.New("count-header cannot be used with index-header or hash-select")
+	}
+
+	if optListenRetry < 0 {
+		return nil, nil, errors.New("listen-retry must not be negative")
+	}
+
+	if optIdleShutdown < 0 {
+		return nil, nil, errors.New("idle-shutdown must not be negative")
+	}
+
+	if optMaxRuntime < 0 {
+		return nil, nil, errors.New("max-runtime must not be negative")
+	}
+
+	if optContinueDelay < 0 {
+		return nil, nil, errors.New("continue-delay must not be negative")
+	}
+
+	if optMaxRequestsPerConn < 0 {
+		return nil, nil, errors.New("max-requests-per-conn must not be negative")
+	}
+
+	if optStartupDelay < 0 {
+		return nil, nil, errors.New("startup-delay must not be negative")
+	}
+
+	if optAcceptDelay < 0 {
+		return nil, nil, errors.New("accept-delay must not be negative")
+	}
+
+	if optDelayRamp < 0 {
+		return nil, nil, errors.New("delay-ramp must not be negative")
+	}
+
+	if optJitter < 0 {
+		return nil, nil, errors.New("jitter must not be negative")
+	}
+	if optJitterByPath && optJitter == 0 {
+		return nil, nil, errors.New("jitter-by-path requires jitter")
+	}
+
+	var syslog *syslogConfig
+	if optSyslog {
+		syslog, err = parseSyslogAddr(optSyslogAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if optSyslogAddr != "" {
+		return nil, nil, errors.New("syslog-addr requires syslog option")
+	}
+
+	if optLogFile != "" {
+		if optSyslog {
+			return nil, nil, errors.New("log-file cannot be used with syslog")
+		}
+		if optLogMaxSize < 0 {
+			return nil, nil, errors.New("log-max-size must not be negative")
+		}
+	} else if optLogMaxSize != 0 {
+		return nil, nil, errors.New("log-max-size requires log-file option")
+	}
+
+	var notFoundTemplate *template.Template
+	if optNotFoundTemplate != "" {
+		notFoundTemplate, err = template.New("not-found").Parse(optNotFoundTemplate)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var delays []time.Duration
+	if optDelayFile != "" {
+		delays, err = loadDelayFile(optDelayFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var prefix, suffix []byte
+	if optBodyPrefix != "" {
+		prefix, err = loadAffix(optBodyPrefix)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if optBodySuffix != "" {
+		suffix, err = loadAffix(optBodySuffix)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
 	return &serverConfig{
-		addr:    fmt.Sprintf(":%d", optPort),
-		headers: headers,
-		tls:     tls,
+		addr:                fmt.Sprintf(":%d", optPort),
+		headers:             headers,
+		tls:                 tls,
+		httpsAddr:           httpsAddr,
+		systemd:             optSystemd,
+		responseDelays:      delays,
+		bodyPrefix:          prefix,
+		bodySuffix:          suffix,
+		indexHeader:         optIndexHeader,
+		crashOnRequest:      optCrashOnRequest,
+		timeFormat:          optTimeFormat,
+		delayRamp:           optDelayRamp,
+		jitter:              optJitter,
+		jitterByPath:        optJitterByPath,
+		mergeHeaders:        optMergeHeaders,
+		announceJSON:        optAnnounceJSON,
+		notFoundTemplate:    notFoundTemplate,
+		rateLimit:           optRateLimit,
+		shutdownWebhook:     optShutdownWebhook,
+		gzipLevel:           optGzipLevel,
+		gzipMinSize:         optGzipMinSize,
+		seed:                optSeed,
+		logSummary:          optLogSummary,
+		acceptDelay:         optAcceptDelay,
+		badRequestBody:      optBadRequestBody,
+		controlPath:         optControlPath,
+		order:               optOrder,
+		requestReadTimeout:  optRequestReadTimeout,
+		syslog:              syslog,
+		honorMethodOverride: optHonorMethodOverride,
+		printPlan:           optPrintPlan,
+		startupDelay:        optStartupDelay,
+		echoClientCert:      optEchoClientCert,
+		requestQuota:        optRequestQuota,
+		refuseProbability:   optRefuseProbability,
+		maxConnsPerIP:       optMaxConnsPerIP,
+		delayFromHeader:     optDelayFromHeader,
+		readBuffer:          optReadBuffer,
+		writeBuffer:         optWriteBuffer,
+		logCurl:             optLogCurl,
+		enableTrace:         optEnableTrace,
+		statusFromPath:      optStatusFromPath,
+		preferAsync:         optPreferAsync,
+		allowEmptyBody:      optAllowEmptyBody,
+		reflectHeaders:      optReflectHeaders,
+		templateErrorBody:   optTemplateErrorBody,
+		dualStack:           optDualStack,
+		ignoreFavicon:       optIgnoreFavicon,
+		noShutdown:          optNoShutdown,
+		loop:                optLoop,
+		idleShutdown:        optIdleShutdown,
+		maxRuntime:          optMaxRuntime,
+		continueDelay:       optContinueDelay,
+		maxRequestsPerConn:  optMaxRequestsPerConn,
+		listenRetry:         optListenRetry,
+		openAPI:             openAPI,
+		roundRobinPath:      optRoundRobinPath,
+		timingLog:           optTimingLog,
+		connectStatus:       optConnectStatus,
+		connectTunnel:       optConnectTunnel,
+		hashSelect:          optHashSelect,
+		countHeader:         optCountHeader,
+		logFile:             optLogFile,
+		logMaxSize:          optLogMaxSize,
+		adminPath:           optAdminPath,
 	}, f.Args(), nil
 }
 
+// loadCertPool reads PEM encoded certificates from the file into a pool.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%s: no certificate found", path)
+	}
+	return pool, nil
+}
+
+// loadDelayFile reads durations, one per line, from the file.
+// Empty lines are ignored.
+func loadDelayFile(path string) ([]time.Duration, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	delays := []time.Duration{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		d, err := time.ParseDuration(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("%s:%d: delay must not be negative", path, i+1)
+		}
+		delays = append(delays, d)
+	}
+	return delays, nil
+}
+
+// parseSyslogAddr parses [<network>://]<host>:<port> of a syslog server.
+// The network defaults to udp. Empty address means the local syslog server.
+func parseSyslogAddr(s string) (*syslogConfig, error) {
+	if s == "" {
+		return &syslogConfig{}, nil
+	}
+	network, addr, ok := strings.Cut(s, "://")
+	if !ok {
+		network, addr = "udp", s
+	}
+	switch network {
+	case "udp", "tcp", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("unsupported syslog network: %q", network)
+	}
+	return &syslogConfig{network: network, addr: addr}, nil
+}
+
 func repeatResponse(resp *responseConfig, repeat int) []*responseConfig {
 	resps := make([]*responseConfig, repeat)
 	for i := range resps {
@@ -109,9 +645,19 @@ func repeatResponse(resp *responseConfig, repeat int) []*responseConfig {
 	return resps
 }
 
+// loadBodyRandom returns loadBody reading size bytes from random regardless of the argument.
+func loadBodyRandom(random io.Reader, size int) loadBody {
+	return func(_ string) ([]byte, error) {
+		b := make([]byte, size)
+		_, err := io.ReadFull(random, b)
+		return b, err
+	}
+}
+
 // parseResponsesPart parses repeat of <status> <body> [options]...
-func parseResponsesPart(args []string) ([]*responseConfig, error) {
-	if len(args) < 2 {
+// If allowEmptyBody, <body> can be omitted at the end of args or before options.
+func parseResponsesPart(args []string, random io.Reader, allowEmptyBody bool) ([]*responseConfig, error) {
+	if len(args) < 2 && !(allowEmptyBody && len(args) == 1) {
 		return nil, errors.New("status code and body are required")
 	}
 
@@ -119,14 +665,21 @@ func parseResponsesPart(args []string) ([]*responseConfig, error) {
 
 	rest := args
 	for len(rest) > 0 {
-		if len(rest) < 2 {
+		// an omitted body is told only by what follows, so that <status> <body> pairs are never split
+		omitBody := allowEmptyBody && (len(rest) == 1 || isOption(rest[1]))
+		if len(rest) < 2 && !omitBody {
 			return nil, errors.New("status code and body are required")
 		}
 		statusCode, err := strconv.Atoi(rest[0])
 		if err != nil {
 			return nil, err
 		}
-		bodyArg := rest[1]
+		// the positional body can be omitted in favor of --body
+		bodyArg, optArgs := "", rest[1:]
+		hasBodyArg := !omitBody && !isBodyFlag(rest[1])
+		if hasBodyArg {
+			bodyArg, optArgs = rest[1], rest[2:]
+		}
 
 		f := flag.NewFlagSet("", flag.ContinueOnError)
 		f.Usage = func() {}
@@ -135,25 +688,371 @@ func parseResponsesPart(args []string) ([]*responseConfig, error) {
 		repeat := 1
 		optHeaders := optStringArray([]string{})
 		loadBody := loadBodyRaw
+		bodyFile := false
+		bodyLimit := 0
+		reloadFile := false
+		messageFile := ""
 		trimNewline := false
+		attachment := ""
+		padHeaders := 0
+		var methodBodies map[string][]byte
+		corruptLength := 0
+		useGzip := false
+		gzipBomb := int64(0)
+		useBrotli := false
+		optCookies := optStringArray([]string{})
+		bodyLines := optStringArray([]string{})
+		alwaysPath := ""
+		untilSignal := false
+		grpcWeb := false
+		grpcWebText := false
+		var matchCookie *http.Cookie
+		requireTLS := false
+		requireBody := false
+		emptyBodyStatus := 0
+		var chaos []chaosWeight
+		bodyEncoding := "raw"
+		trailerChecksum := ""
+		badDigest := ""
+		chaosDelay := time.Duration(0)
+		var activeFrom, activeUntil *activeTime
+		useTemplate := false
+		ndjson := false
+		ndjsonInterval := time.Duration(0)
+		sse := false
+		sseInterval := time.Duration(0)
+		sseKeepalive := time.Duration(0)
+		matchLanguage := ""
+		method := ""
+		alsoSend := 0
+		obsFold := optStringArray([]string{})
+		crlf := false
+		useMultipart := false
+		redirectPermanent := ""
+		redirectTemporary := ""
+		optParts := optStringArray([]string{})
+		lf := false
 
 		f.IntVar(&repeat, "r", 1, "")
 		f.IntVar(&repeat, "repeat", 1, "")
 		f.Var(&optHeaders, "H", "")
 		f.Var(&optHeaders, "header", "")
-		f.BoolFunc("body-file", "", func(_ string) error { loadBody = loadBodyFile; return nil })
+		f.BoolFunc("body-file", "", func(_ string) error { loadBody = loadBodyFile; bodyFile = true; return nil })
+		f.BoolVar(&reloadFile, "reload-file", false, "")
+		f.StringVar(&messageFile, "message-file", "", "")
+		f.Func("body-limit", "", func(s string) error {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return err
+			}
+			if n <= 0 {
+				return errors.New("body-limit must be positive")
+			}
+			bodyLimit = n
+			return nil
+		})
+		f.Func("random-body", "", func(s string) error {
+			size, err := strconv.Atoi(s)
+			if err != nil {
+				return err
+			}
+			if size <= 0 {
+				return errors.New("random-body size must be positive")
+			}
+			loadBody = loadBodyRandom(random, size)
+			return nil
+		})
 		f.BoolVar(&trimNewline, "trim-newline", false, "")
+		f.StringVar(&attachment, "attachment", "", "")
+		f.Func("pad-headers", "", func(s string) (err error) {
+			padHeaders, err = strconv.Atoi(s)
+			if err != nil {
+				return err
+			}
+			if padHeaders <= 0 {
+				return errors.New("pad-headers size must be positive")
+			}
+			return nil
+		})
+		f.IntVar(&corruptLength, "corrupt-length", 0, "")
+		f.BoolVar(&useGzip, "gzip", false, "")
+		f.Int64Var(&gzipBomb, "gzip-bomb", 0, "")
+		f.BoolVar(&useBrotli, "brotli", false, "")
+		f.Var(&optCookies, "cookie", "")
+		f.Var(&bodyLines, "body", "")
+		f.StringVar(&alwaysPath, "always", "", "")
+		f.BoolVar(&untilSignal, "until-signal", false, "")
+		f.BoolVar(&grpcWeb, "grpc-web", false, "")
+		f.BoolVar(&grpcWebText, "grpc-web-text", false, "")
+		f.BoolVar(&requireTLS, "require-tls", false, "")
+		f.BoolVar(&requireBody, "require-body", false, "")
+		f.IntVar(&emptyBodyStatus, "empty-body-status", 0, "")
+		f.DurationVar(&chaosDelay, "chaos-delay", 0, "")
+		f.StringVar(&bodyEncoding, "body-encoding", "raw", "")
+		f.StringVar(&trailerChecksum, "trailer-checksum", "", "")
+		f.StringVar(&badDigest, "bad-digest", "", "")
+		f.Func("active-from", "", func(s string) error {
+			var err error
+			activeFrom, err = parseActiveTime(s)
+			return err
+		})
+		f.Func("active-until", "", func(s string) error {
+			var err error
+			activeUntil, err = parseActiveTime(s)
+			return err
+		})
+		f.Func("chaos", "", func(s string) error {
+			var err error
+			chaos, err = parseChaos(s)
+			return err
+		})
+		f.BoolVar(&useTemplate, "template", false, "")
+		f.BoolVar(&ndjson, "ndjson", false, "")
+		f.StringVar(&matchLanguage, "match-language", "", "")
+		f.Func("method", "", func(s string) error {
+			if s == "" || strings.ContainsAny(s, " \t\r\n") {
+				return fmt.Errorf("invalid method: %q", s)
+			}
+			method = strings.ToUpper(s)
+			return nil
+		})
+		f.IntVar(&alsoSend, "also-send", 0, "")
+		f.Var(&obsFold, "obs-fold", "")
+		f.BoolVar(&crlf, "crlf", false, "")
+		f.BoolVar(&useMultipart, "multipart", false, "")
+		f.StringVar(&redirectPermanent, "redirect-permanent", "", "")
+		f.StringVar(&redirectTemporary, "redirect-temporary", "", "")
+		f.Var(&optParts, "part", "")
+		f.BoolVar(&lf, "lf", false, "")
+		f.DurationVar(&ndjsonInterval, "ndjson-interval", 0, "")
+		f.BoolVar(&sse, "sse", false, "")
+		f.DurationVar(&sseInterval, "sse-interval", 0, "")
+		f.DurationVar(&sseKeepalive, "sse-keepalive", 0, "")
+		f.Func("match-cookie", "", func(s string) error {
+			name, value, ok := strings.Cut(s, "=")
+			if !ok || name == "" {
+				return fmt.Errorf("invalid match-cookie: %q", s)
+			}
+			matchCookie = &http.Cookie{Name: name, Value: value}
+			return nil
+		})
+		f.Func("method-body", "", func(s string) error {
+			if methodBodies == nil {
+				methodBodies = map[string][]byte{}
+			}
+			return parseMethodBodies(s, methodBodies)
+		})
+		f.Func("headers-json", "", func(s string) error {
+			hs, err := parseHeadersJSON(s)
+			if err != nil {
+				return err
+			}
+			optHeaders = append(optHeaders, hs...)
+			return nil
+		})
 
-		if err := f.Parse(rest[2:]); err != nil {
+		if err := f.Parse(optArgs); err != nil {
 			return nil, err
 		}
 
+		if len(bodyLines) > 0 {
+			if hasBodyArg {
+				return nil, errors.New("body must not be given both as an argument and by --body")
+			}
+			bodyArg = strings.Join(bodyLines, "\n")
+		}
+
+		if messageFile != "" {
+			if hasBodyArg || len(bodyLines) > 0 {
+				return nil, errors.New("body must not be given both as an argument or by --body and by --message-file")
+			}
+			if bodyFile || reloadFile || useMultipart || gzipBomb != 0 || redirectPermanent != "" || redirectTemporary != "" {
+				return nil, errors.New("message-file cannot be used with body-file, reload-file, multipart, gzip-bomb or redirects")
+			}
+		}
+
 		if repeat <= 0 {
 			return nil, errors.New("repeat must be positive")
 		}
 
-		body, err := loadBody(bodyArg)
-		if err != nil {
+		if alwaysPath != "" {
+			if !strings.HasPrefix(alwaysPath, "/") {
+				return nil, errors.New("always path must start with /")
+			}
+			if repeat != 1 {
+				return nil, errors.New("repeat cannot be used with always")
+			}
+		}
+
+		if untilSignal && alwaysPath != "" {
+			return nil, errors.New("until-signal cannot be used with always")
+		}
+
+		if useGzip && useBrotli {
+			return nil, errors.New("gzip cannot be used with brotli")
+		}
+
+		if ndjson {
+			if useGzip || useBrotli || grpcWeb || grpcWebText || corruptLength != 0 {
+				return nil, errors.New("ndjson cannot be used with gzip, brotli, grpc-web or corrupt-length")
+			}
+			if ndjsonInterval < 0 {
+				return nil, errors.New("ndjson-interval must not be negative")
+			}
+		} else if ndjsonInterval != 0 {
+			return nil, errors.New("ndjson-interval requires ndjson")
+		}
+
+		if sse {
+			if ndjson || useGzip || useBrotli || grpcWeb || grpcWebText || corruptLength != 0 {
+				return nil, errors.New("sse cannot be used with ndjson, gzip, brotli, grpc-web or corrupt-length")
+			}
+			if sseInterval < 0 || sseKeepalive < 0 {
+				return nil, errors.New("sse-interval and sse-keepalive must not be negative")
+			}
+			if sseKeepalive != 0 && sseInterval == 0 {
+				return nil, errors.New("sse-keepalive requires sse-interval")
+			}
+		} else if sseInterval != 0 || sseKeepalive != 0 {
+			return nil, errors.New("sse-interval and sse-keepalive require sse")
+		}
+
+		var multipartParts []multipartPart
+		if useMultipart {
+			if bodyArg != "" {
+				return nil, errors.New("body must be empty with multipart")
+			}
+			if len(optParts) == 0 {
+				return nil, errors.New("multipart requires part options")
+			}
+			for _, s := range optParts {
+				part, err := parseMultipartPart(s)
+				if err != nil {
+					return nil, err
+				}
+				multipartParts = append(multipartParts, part)
+			}
+		} else if len(optParts) > 0 {
+			return nil, errors.New("part requires multipart")
+		}
+
+		lineEnding := ""
+		if crlf && lf {
+			return nil, errors.New("crlf cannot be used with lf")
+		} else if crlf {
+			lineEnding = "crlf"
+		} else if lf {
+			lineEnding = "lf"
+		}
+
+		if gzipBomb < 0 {
+			return nil, errors.New("gzip-bomb must be positive")
+		} else if gzipBomb > 0 {
+			if bodyArg != "" {
+				return nil, errors.New("body must be empty with gzip-bomb")
+			}
+			if useGzip || useBrotli || ndjson || grpcWeb || grpcWebText || useTemplate || useMultipart || reloadFile || bodyFile || lineEnding != "" {
+				return nil, errors.New("gzip-bomb cannot be used with gzip, brotli, ndjson, grpc-web, template, multipart, body-file, reload-file, crlf or lf")
+			}
+		}
+
+		if requireBody {
+			if emptyBodyStatus == 0 {
+				emptyBodyStatus = http.StatusBadRequest
+			} else if emptyBodyStatus < 100 || emptyBodyStatus > 599 {
+				return nil, errors.New("empty-body-status must be between 100 and 599")
+			}
+		} else if emptyBodyStatus != 0 {
+			return nil, errors.New("empty-body-status requires require-body")
+		}
+
+		if chaos != nil {
+			if chaosDelay < 0 {
+				return nil, errors.New("chaos-delay must not be negative")
+			} else if chaosDelay == 0 {
+				chaosDelay = time.Second
+			}
+		} else if chaosDelay != 0 {
+			return nil, errors.New("chaos-delay requires chaos")
+		}
+
+		if activeFrom != nil && activeUntil != nil && activeFrom.at.IsZero() == activeUntil.at.IsZero() {
+			// bounds of different kinds are only comparable once the server starts
+			if activeUntil.offset <= activeFrom.offset && !activeUntil.at.After(activeFrom.at) {
+				return nil, errors.New("active-until must be after active-from")
+			}
+		}
+
+		if badDigest != "" {
+			if _, ok := digestAlgorithms[badDigest]; !ok {
+				return nil, fmt.Errorf("bad-digest must be md5 or sha256: %q", badDigest)
+			}
+			if trailerChecksum != "" {
+				return nil, errors.New("bad-digest cannot be used with trailer-checksum")
+			}
+		}
+
+		if trailerChecksum != "" {
+			if _, ok := digestAlgorithms[trailerChecksum]; !ok {
+				return nil, fmt.Errorf("trailer-checksum must be md5 or sha256: %q", trailerChecksum)
+			}
+			if ndjson || corruptLength != 0 || alsoSend != 0 {
+				return nil, errors.New("trailer-checksum cannot be used with ndjson, corrupt-length or also-send")
+			}
+		}
+
+		var obsFoldHeaders http.Header
+		if len(obsFold) > 0 {
+			if ndjson || corruptLength != 0 || alsoSend != 0 || trailerChecksum != "" {
+				return nil, errors.New("obs-fold cannot be used with ndjson, corrupt-length, also-send or trailer-checksum")
+			}
+			var err error
+			if obsFoldHeaders, err = parseHeaders(obsFold); err != nil {
+				return nil, fmt.Errorf("invalid obs-fold header: %w", err)
+			}
+		}
+
+		if alsoSend < 0 {
+			return nil, errors.New("also-send must not be negative")
+		}
+		if alsoSend > 0 && (ndjson || corruptLength != 0) {
+			return nil, errors.New("also-send cannot be used with ndjson or corrupt-length")
+		}
+
+		if corruptLength < 0 {
+			return nil, errors.New("corrupt-length must not be negative")
+		}
+
+		if bodyLimit > 0 {
+			if !bodyFile {
+				return nil, errors.New("body-limit requires body-file")
+			}
+			loadBody = loadBodyFileLimit(bodyLimit)
+		}
+
+		switch bodyEncoding {
+		case "raw":
+		case "escaped":
+			bodyArg, err = unescapeBody(bodyArg)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("body-encoding must be raw or escaped: %q", bodyEncoding)
+		}
+
+		var body []byte
+		reloadPath := ""
+		if reloadFile {
+			if !bodyFile {
+				return nil, errors.New("reload-file requires body-file")
+			}
+			if useTemplate || ndjson || useMultipart || trimNewline {
+				return nil, errors.New("reload-file cannot be used with template, ndjson, multipart or trim-newline")
+			}
+			// the file is read on every request, so it need not exist yet
+			body, reloadPath = []byte{}, bodyArg
+		} else if body, err = loadBody(bodyArg); err != nil {
 			return nil, err
 		}
 
@@ -161,24 +1060,196 @@ func parseResponsesPart(args []string) ([]*responseConfig, error) {
 			body = bytes.Trim(body, "\n")
 		}
 
+		if gzipBomb > 0 {
+			body = gzipBombBody(gzipBomb)
+		}
+
+		var messageHeaders http.Header
+		if messageFile != "" {
+			statusCode, messageHeaders, body, err = loadMessageFile(messageFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var bodyTemplate *template.Template
+		if useTemplate {
+			bodyTemplate, err = template.New("body").Parse(string(body))
+			if err != nil {
+				return nil, err
+			}
+		} else if ndjson {
+			if err := validateNDJSON(body); err != nil {
+				return nil, err
+			}
+		}
+
 		headers, err := parseHeaders(optHeaders)
 		if err != nil {
 			return nil, err
 		}
+		// the options override the headers of the message file
+		for name, values := range messageHeaders {
+			if _, ok := headers[name]; !ok {
+				headers[name] = values
+			}
+		}
+
+		for _, spec := range optCookies {
+			cookie, err := parseCookie(spec)
+			if err != nil {
+				return nil, err
+			}
+			headers.Add("Set-Cookie", cookie.String())
+		}
+
+		if attachment != "" {
+			disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment})
+			if disposition == "" {
+				return nil, fmt.Errorf("invalid attachment filename: %q", attachment)
+			}
+			headers.Set("Content-Disposition", disposition)
+		}
+
+		// the redirects preserve the method, unlike 301 and 302
+		if redirectPermanent != "" && redirectTemporary != "" {
+			return nil, errors.New("redirect-permanent cannot be used with redirect-temporary")
+		} else if redirectPermanent != "" {
+			statusCode = http.StatusPermanentRedirect
+			headers.Set("Location", redirectPermanent)
+		} else if redirectTemporary != "" {
+			statusCode = http.StatusTemporaryRedirect
+			headers.Set("Location", redirectTemporary)
+		}
+
+		if padHeaders > 0 {
+			headers.Set(padHeaderName, strings.Repeat("a", padHeaders))
+		}
 
 		resp := &responseConfig{
-			statusCode: statusCode,
-			body:       []byte(body),
-			headers:    headers,
+			statusCode:      statusCode,
+			body:            []byte(body),
+			headers:         headers,
+			methodBodies:    methodBodies,
+			corruptLength:   corruptLength,
+			gzip:            useGzip,
+			brotli:          useBrotli,
+			alwaysPath:      alwaysPath,
+			untilSignal:     untilSignal,
+			grpcWeb:         grpcWeb || grpcWebText,
+			grpcWebText:     grpcWebText,
+			matchCookie:     matchCookie,
+			requireTLS:      requireTLS,
+			requireBody:     emptyBodyStatus,
+			trailerChecksum: trailerChecksum,
+			badDigest:       badDigest,
+			chaos:           chaos,
+			chaosDelay:      chaosDelay,
+			activeFrom:      activeFrom,
+			activeUntil:     activeUntil,
+			bodyTemplate:    bodyTemplate,
+			reloadFile:      reloadPath,
+			reloadLimit:     bodyLimit,
+			ndjson:          ndjson,
+			ndjsonInterval:  ndjsonInterval,
+			sse:             sse,
+			sseInterval:     sseInterval,
+			sseKeepalive:    sseKeepalive,
+			matchLanguage:   matchLanguage,
+			method:          method,
+			alsoSend:        alsoSend,
+			obsFold:         obsFoldHeaders,
+			lineEnding:      lineEnding,
+			multipartParts:  multipartParts,
+			gzipBomb:        gzipBomb,
 		}
 		resps = append(resps, repeatResponse(resp, repeat)...)
 		rest = f.Args()
 	}
 
+	for i, r := range resps {
+		if !r.untilSignal {
+			continue
+		}
+		for _, next := range resps[i+1:] {
+			if next.alwaysPath == "" {
+				return nil, errors.New("until-signal must be the last response of the sequence")
+			}
+		}
+	}
+
 	return resps, nil
 }
 
+// padHeaderName is the name of the dummy header added by --pad-headers.
+const padHeaderName = "X-Pad"
+
+// isOption reports whether the argument is an option, "-" or "--" followed by a letter,
+// so that bodies starting with "-" such as "-1" are not taken for options.
+func isOption(arg string) bool {
+	name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+	if len(name) == len(arg) || name == "" {
+		return false
+	}
+	c := name[0]
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// isBodyFlag reports whether the argument is an option giving the body instead of <body>:
+// --body or --message-file.
+func isBodyFlag(arg string) bool {
+	for _, name := range []string{"body", "message-file"} {
+		if arg == "--"+name || arg == "-"+name || strings.HasPrefix(arg, "--"+name+"=") || strings.HasPrefix(arg, "-"+name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeBody interprets the escape sequences of Go string literals in s such as \n, \t and \x00.
+// Quotes can appear without escapes unlike in the literals.
+func unescapeBody(s string) (string, error) {
+	var b strings.Builder
+	for len(s) > 0 {
+		if s[0] == '"' {
+			b.WriteByte('"')
+			s = s[1:]
+			continue
+		}
+		r, multibyte, tail, err := strconv.UnquoteChar(s, '"')
+		if err != nil {
+			return "", fmt.Errorf("invalid escape sequence in body: %q", s)
+		}
+		if r < utf8.RuneSelf || !multibyte {
+			// \x and octal escapes are bytes rather than runes
+			b.WriteByte(byte(r))
+		} else {
+			b.WriteRune(r)
+		}
+		s = tail
+	}
+	return b.String(), nil
+}
+
+// parseMethodBodies parses comma separated <method>:<body> pairs into bodies.
+func parseMethodBodies(s string, bodies map[string][]byte) error {
+	for _, pair := range strings.Split(s, ",") {
+		method, body, ok := strings.Cut(pair, ":")
+		if !ok || method == "" {
+			return fmt.Errorf("invalid method body: %q", pair)
+		}
+		bodies[strings.ToUpper(method)] = []byte(body)
+	}
+	return nil
+}
+
 func parseHeaders(headerStrings []string) (http.Header, error) {
+	for _, h := range headerStrings {
+		// a line break would inject another header
+		if strings.ContainsAny(h, "\r\n") {
+			return nil, fmt.Errorf("invalid header: %q", h)
+		}
+	}
 	bufr := bufio.NewReader(strings.NewReader(strings.Join(headerStrings, "\r\n") + "\r\n\r\n"))
 	r := textproto.NewReader(bufr)
 	header, err := r.ReadMIMEHeader()
@@ -193,3 +1264,41 @@ func parseHeaders(headerStrings []string) (http.Header, error) {
 	}
 	return httpHeader, nil
 }
+
+// parseHeadersJSON converts a JSON object of header name to a value or an array of values
+// into header strings accepted by parseHeaders.
+func parseHeadersJSON(s string) ([]string, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(s), &obj); err != nil {
+		return nil, fmt.Errorf("invalid headers json: %w", err)
+	}
+	if obj == nil {
+		return nil, errors.New("headers json must be an object")
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := []string{}
+	for _, name := range names {
+		var value string
+		if string(obj[name]) == "null" {
+			return nil, fmt.Errorf("header %q must be a string or an array of strings", name)
+		}
+		if err := json.Unmarshal(obj[name], &value); err == nil {
+			headers = append(headers, name+": "+value)
+			continue
+		}
+		var values []*string
+		if err := json.Unmarshal(obj[name], &values); err != nil || slices.Contains(values, nil) {
+			return nil, fmt.Errorf("header %q must be a string or an array of strings", name)
+		}
+		for _, v := range values {
+			headers = append(headers, name+": "+*v)
+		}
+	}
+	return headers, nil
+}
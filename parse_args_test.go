@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
 	"path"
 	"reflect"
 	"runtime"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func serverToString(s *serverConfig) string {
@@ -191,6 +196,598 @@ func TestParseArgsSuccess(t *testing.T) {
 				}(),
 			},
 		},
+		{
+			name: "WithAttachment",
+			args: []string{
+				"200",
+				"OK",
+				"--attachment",
+				"my report.csv",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers: httpHeader(map[string][]string{
+							"Content-Disposition": {`attachment; filename="my report.csv"`},
+						}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithPadHeaders",
+			args: []string{
+				"200",
+				"OK",
+				"--pad-headers",
+				"16",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers: httpHeader(map[string][]string{
+							"X-Pad": {"aaaaaaaaaaaaaaaa"},
+						}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithMatchCookie",
+			args: []string{
+				"200",
+				"OK",
+				"--match-cookie",
+				"session=a=b",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode:  200,
+						body:        []byte("OK"),
+						headers:     httpHeader(map[string][]string{}),
+						matchCookie: &http.Cookie{Name: "session", Value: "a=b"},
+					},
+				},
+			},
+		},
+		{
+			name: "AllowEmptyBody",
+			args: []string{
+				"--allow-empty-body",
+				"200",
+				"-H",
+				"X-A: 1",
+				"201",
+				"created",
+				"204",
+			},
+			expect: &serverConfig{
+				addr:           ":8080",
+				headers:        httpHeader(map[string][]string{}),
+				allowEmptyBody: true,
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte(""),
+						headers: httpHeader(map[string][]string{
+							"X-A": {"1"},
+						}),
+					},
+					{
+						statusCode: 201,
+						body:       []byte("created"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+					{
+						statusCode: 204,
+						body:       []byte(""),
+						headers:    httpHeader(map[string][]string{}),
+					},
+				},
+			},
+		},
+		{
+			name: "AllowEmptyBodyWithDashBody",
+			args: []string{
+				"--allow-empty-body",
+				"200",
+				"-1",
+				"201",
+				"---",
+				"204",
+			},
+			expect: &serverConfig{
+				addr:           ":8080",
+				headers:        httpHeader(map[string][]string{}),
+				allowEmptyBody: true,
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("-1"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+					{
+						statusCode: 201,
+						body:       []byte("---"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+					{
+						statusCode: 204,
+						body:       []byte(""),
+						headers:    httpHeader(map[string][]string{}),
+					},
+				},
+			},
+		},
+		{
+			name: "AllowEmptyBodyKeepsPairs",
+			args: []string{
+				"--allow-empty-body",
+				"200",
+				"404",
+				"500",
+			},
+			expect: &serverConfig{
+				addr:           ":8080",
+				headers:        httpHeader(map[string][]string{}),
+				allowEmptyBody: true,
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("404"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+					{
+						statusCode: 500,
+						body:       []byte(""),
+						headers:    httpHeader(map[string][]string{}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithRequireBody",
+			args: []string{
+				"200",
+				"OK",
+				"--require-body",
+				"201",
+				"OK",
+				"--require-body",
+				"--empty-body-status",
+				"422",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode:  200,
+						body:        []byte("OK"),
+						headers:     httpHeader(map[string][]string{}),
+						requireBody: 400,
+					},
+					{
+						statusCode:  201,
+						body:        []byte("OK"),
+						headers:     httpHeader(map[string][]string{}),
+						requireBody: 422,
+					},
+				},
+			},
+		},
+		{
+			name: "WithChaos",
+			args: []string{
+				"200",
+				"OK",
+				"--chaos",
+				"normal:3,delay:1",
+				"201",
+				"OK",
+				"--chaos",
+				"drop:1",
+				"--chaos-delay",
+				"500ms",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers:    httpHeader(map[string][]string{}),
+						chaos:      []chaosWeight{{chaosNormal, 3}, {chaosDelay, 1}},
+						chaosDelay: time.Second,
+					},
+					{
+						statusCode: 201,
+						body:       []byte("OK"),
+						headers:    httpHeader(map[string][]string{}),
+						chaos:      []chaosWeight{{chaosDrop, 1}},
+						chaosDelay: 500 * time.Millisecond,
+					},
+				},
+			},
+		},
+		{
+			name: "WithBodyEncodingEscaped",
+			args: []string{
+				"200",
+				`a\tb\r\n\x00\xff\u00e9"q"\\`,
+				"--body-encoding",
+				"escaped",
+				"200",
+				`a\tb`,
+				"--body-encoding",
+				"raw",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("a\tb\r\n\x00\xff\u00e9\"q\"\\"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+					{
+						statusCode: 200,
+						body:       []byte(`a\tb`),
+						headers:    httpHeader(map[string][]string{}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithActiveWindow",
+			args: []string{
+				"200",
+				"OK",
+				"--active-from",
+				"30s",
+				"--active-until",
+				"2024-01-01T00:00:00Z",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode:  200,
+						body:        []byte("OK"),
+						headers:     httpHeader(map[string][]string{}),
+						activeFrom:  &activeTime{offset: 30 * time.Second},
+						activeUntil: &activeTime{at: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+					},
+				},
+			},
+		},
+		{
+			name: "WithMessageFile",
+			args: []string{
+				"200",
+				"--message-file",
+				path.Join(dir, "testdata/message.txt"),
+				"-H",
+				"X-Request-Id: override",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode: 404,
+						body:       []byte(`{"error":"not found"}`),
+						headers: httpHeader(map[string][]string{
+							"Content-Type": {"application/json"},
+							"X-Request-Id": {"override"},
+						}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithResponseDelayFile",
+			args: []string{
+				"--response-delay-file",
+				path.Join(dir, "testdata/delays.txt"),
+				"200",
+				"OK",
+				"500",
+				"Internal Server Error",
+			},
+			expect: &serverConfig{
+				addr:           ":8080",
+				headers:        httpHeader(map[string][]string{}),
+				responseDelays: []time.Duration{0, 100 * time.Millisecond},
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+					{
+						statusCode: 500,
+						body:       []byte("Internal Server Error"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithBodyAffix",
+			args: []string{
+				"--body-prefix",
+				"{\"data\":",
+				"--body-suffix",
+				"}",
+				"200",
+				"OK",
+			},
+			expect: &serverConfig{
+				addr:       ":8080",
+				headers:    httpHeader(map[string][]string{}),
+				bodyPrefix: []byte("{\"data\":"),
+				bodySuffix: []byte("}"),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithBodyAffixFile",
+			args: []string{
+				"--body-affix-file",
+				"--body-prefix",
+				path.Join(dir, "testdata/body.txt"),
+				"200",
+				"OK",
+			},
+			expect: &serverConfig{
+				addr:       ":8080",
+				headers:    httpHeader(map[string][]string{}),
+				bodyPrefix: []byte("body from file\n"),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithHeadersJSON",
+			args: []string{
+				"200",
+				"OK",
+				"--headers-json",
+				`{"single-header": "value", "multi-header": ["value1", "value2"]}`,
+				"-H",
+				"multi-header: value3",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers: httpHeader(map[string][]string{
+							"single-header": {"value"},
+							"multi-header":  {"value1", "value2", "value3"},
+						}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithMethodBody",
+			args: []string{
+				"200",
+				"default",
+				"--method-body",
+				"get:body1,POST:body2",
+				"--method-body",
+				"DELETE:",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("default"),
+						headers:    httpHeader(map[string][]string{}),
+						methodBodies: map[string][]byte{
+							"GET":    []byte("body1"),
+							"POST":   []byte("body2"),
+							"DELETE": []byte(""),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "WithGzip",
+			args: []string{
+				"--gzip-level",
+				"1",
+				"200",
+				"OK",
+				"--gzip",
+			},
+			expect: &serverConfig{
+				addr:      ":8080",
+				headers:   httpHeader(map[string][]string{}),
+				gzipLevel: 1,
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers:    httpHeader(map[string][]string{}),
+						gzip:       true,
+					},
+				},
+			},
+		},
+		{
+			name: "WithCookies",
+			args: []string{
+				"200",
+				"OK",
+				"--cookie",
+				"session=abc; Path=/; HttpOnly",
+				"--cookie",
+				"theme=dark; Max-Age=60",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers: httpHeader(map[string][]string{
+							"Set-Cookie": {"session=abc; Path=/; HttpOnly", "theme=dark; Max-Age=60"},
+						}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithBodyLines",
+			args: []string{
+				"200",
+				"--body",
+				"line1",
+				"--body=line2",
+				"-H",
+				"test-header: header",
+				"--body",
+				"",
+				"201",
+				"-body",
+				"single line",
+				"202",
+				"positional",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("line1\nline2\n"),
+						headers: httpHeader(map[string][]string{
+							"test-header": {"header"},
+						}),
+					},
+					{
+						statusCode: 201,
+						body:       []byte("single line"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+					{
+						statusCode: 202,
+						body:       []byte("positional"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithAlways",
+			args: []string{
+				"200",
+				"healthy",
+				"--always",
+				"/health",
+				"503",
+				"Service Unavailable",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("healthy"),
+						headers:    httpHeader(map[string][]string{}),
+						alwaysPath: "/health",
+					},
+					{
+						statusCode: 503,
+						body:       []byte("Service Unavailable"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithServerHeader",
+			args: []string{
+				"--server-header",
+				"nginx/1.25.0",
+				"200",
+				"OK",
+			},
+			expect: &serverConfig{
+				addr: ":8080",
+				headers: httpHeader(map[string][]string{
+					"Server": {"nginx/1.25.0"},
+				}),
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+				},
+			},
+		},
+		{
+			name: "WithSyslogAddr",
+			args: []string{
+				"--syslog",
+				"--syslog-addr",
+				"tcp://logs.example.com:514",
+				"200",
+				"OK",
+			},
+			expect: &serverConfig{
+				addr:    ":8080",
+				headers: httpHeader(map[string][]string{}),
+				syslog: &syslogConfig{
+					network: "tcp",
+					addr:    "logs.example.com:514",
+				},
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers:    httpHeader(map[string][]string{}),
+					},
+				},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -210,70 +807,929 @@ func TestParseArgsSuccess(t *testing.T) {
 }
 
 func TestParseArgsFailure(t *testing.T) {
-	cases := []struct {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Dir(filename)
+
+	cases := []struct {
 		name string
 		args []string
 	}{
 		{
-			name: "InvalidPort",
+			name: "InvalidPort",
+			args: []string{
+				"-p",
+				"port",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "NoStatusCode",
+			args: []string{},
+		},
+		{
+			name: "NoBody",
+			args: []string{
+				"200",
+			},
+		},
+		{
+			name: "InvalidRepeat",
+			args: []string{
+				"200",
+				"OK",
+				"-r",
+				"invalid",
+			},
+		},
+		{
+			name: "NegativeRepeat",
+			args: []string{
+				"200",
+				"OK",
+				"-r",
+				"-1",
+			},
+		},
+		{
+			name: "ZeroRepeat",
+			args: []string{
+				"200",
+				"OK",
+				"-r",
+				"0",
+			},
+		},
+		{
+			name: "InvalidHeaderInGrobalOptions",
+			args: []string{
+				"-H",
+				"invalid",
+			},
+		},
+		{
+			name: "InvalidHeaderInResponseOptions",
+			args: []string{
+				"200",
+				"OK",
+				"-H",
+				"invalid",
+			},
+		},
+		{
+			name: "InvalidHeadersJSON",
+			args: []string{
+				"200",
+				"OK",
+				"--headers-json",
+				`{"header": "value"`,
+			},
+		},
+		{
+			name: "NonStringValueInHeadersJSON",
+			args: []string{
+				"200",
+				"OK",
+				"--headers-json",
+				`{"header": 1}`,
+			},
+		},
+		{
+			name: "NonStringArrayValueInHeadersJSON",
+			args: []string{
+				"200",
+				"OK",
+				"--headers-json",
+				`{"header": ["value", true]}`,
+			},
+		},
+		{
+			name: "NullHeadersJSON",
+			args: []string{
+				"200",
+				"OK",
+				"--headers-json",
+				`null`,
+			},
+		},
+		{
+			name: "NullValueInHeadersJSON",
+			args: []string{
+				"200",
+				"OK",
+				"--headers-json",
+				`{"header": null}`,
+			},
+		},
+		{
+			name: "NullArrayValueInHeadersJSON",
+			args: []string{
+				"200",
+				"OK",
+				"--headers-json",
+				`{"header": ["value", null]}`,
+			},
+		},
+		{
+			name: "LineBreakInHeadersJSON",
+			args: []string{
+				"200",
+				"OK",
+				"--headers-json",
+				`{"header": "value\r\nX-Injected: yes"}`,
+			},
+		},
+		{
+			name: "LineBreakInHeader",
+			args: []string{
+				"200",
+				"OK",
+				"-H",
+				"header: value\nX-Injected: yes",
+			},
+		},
+		{
+			name: "InvalidDelayRamp",
+			args: []string{
+				"--delay-ramp",
+				"fast",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "NegativeDelayRamp",
+			args: []string{
+				"--delay-ramp",
+				"-50ms",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "InvalidMethodBody",
+			args: []string{
+				"200",
+				"OK",
+				"--method-body",
+				"GET:body1,body2",
+			},
+		},
+		{
+			name: "InvalidNotFoundTemplate",
+			args: []string{
+				"--not-found-template",
+				"{{.Path",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "NegativeCorruptLength",
+			args: []string{
+				"200",
+				"OK",
+				"--corrupt-length",
+				"-1",
+			},
+		},
+		{
+			name: "ZeroGzipLevel",
+			args: []string{
+				"--gzip-level",
+				"0",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "TooLargeGzipLevel",
+			args: []string{
+				"--gzip-level",
+				"10",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "HTTPSPortWithoutCert",
+			args: []string{
+				"--https-port",
+				"8443",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "TLSHandshakeDelayWithoutCert",
+			args: []string{
+				"--tls-handshake-delay",
+				"1s",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "ZeroRandomBody",
+			args: []string{
+				"200",
+				"OK",
+				"--random-body",
+				"0",
+			},
+		},
+		{
+			name: "InvalidSeed",
+			args: []string{
+				"--seed",
+				"seed",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "InvalidCookie",
+			args: []string{
+				"200",
+				"OK",
+				"--cookie",
+				"session=abc; SameSite=Loose",
+			},
+		},
+		{
+			name: "InvalidOrder",
+			args: []string{
+				"--order",
+				"random",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "BodyArgumentAndBodyOption",
+			args: []string{
+				"200",
+				"OK",
+				"--body",
+				"line",
+			},
+		},
+		{
+			name: "AlwaysPathWithoutSlash",
+			args: []string{
+				"200",
+				"OK",
+				"--always",
+				"health",
+			},
+		},
+		{
+			name: "AlwaysWithRepeat",
+			args: []string{
+				"200",
+				"OK",
+				"--always",
+				"/health",
+				"-r",
+				"2",
+			},
+		},
+		{
+			name: "UntilSignalNotLast",
+			args: []string{
+				"200",
+				"OK",
+				"--until-signal",
+				"500",
+				"Internal Server Error",
+			},
+		},
+		{
+			name: "UntilSignalWithRepeat",
+			args: []string{
+				"200",
+				"OK",
+				"--until-signal",
+				"-r",
+				"2",
+			},
+		},
+		{
+			name: "SyslogAddrWithoutSyslog",
+			args: []string{
+				"--syslog-addr",
+				"localhost:514",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "UnsupportedSyslogNetwork",
+			args: []string{
+				"--syslog",
+				"--syslog-addr",
+				"http://localhost:514",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "ALPNWithoutCert",
+			args: []string{
+				"--alpn",
+				"http/1.1",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "BodyLimitWithoutBodyFile",
+			args: []string{
+				"200",
+				"OK",
+				"--body-limit",
+				"1",
+			},
+		},
+		{
+			name: "NonPositiveBodyLimit",
+			args: []string{
+				"200",
+				path.Join(dir, "testdata/body.txt"),
+				"--body-file",
+				"--body-limit",
+				"0",
+			},
+		},
+		{
+			name: "GzipWithBrotli",
+			args: []string{
+				"200",
+				"OK",
+				"--gzip",
+				"--brotli",
+			},
+		},
+		{
+			name: "NonPositivePadHeaders",
+			args: []string{
+				"200",
+				"OK",
+				"--pad-headers",
+				"0",
+			},
+		},
+		{
+			name: "InvalidMatchCookie",
+			args: []string{
+				"200",
+				"OK",
+				"--match-cookie",
+				"session",
+			},
+		},
+		{
+			name: "InvalidMethod",
+			args: []string{
+				"200",
+				"OK",
+				"--method",
+				"GET /",
+			},
+		},
+		{
+			name: "RequireTLSWithoutCert",
+			args: []string{
+				"200",
+				"OK",
+				"--require-tls",
+			},
+		},
+		{
+			name: "InvalidBodyTemplate",
+			args: []string{
+				"200",
+				"{{.Index",
+				"--template",
+			},
+		},
+		{
+			name: "EchoClientCertWithoutClientCA",
+			args: []string{
+				"--echo-client-cert",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "InvalidNDJSON",
+			args: []string{
+				"200",
+				"{\"n\":1}\nnot json",
+				"--ndjson",
+			},
+		},
+		{
+			name: "NDJSONWithGzip",
+			args: []string{
+				"200",
+				"{}",
+				"--ndjson",
+				"--gzip",
+			},
+		},
+		{
+			name: "NDJSONIntervalWithoutNDJSON",
+			args: []string{
+				"200",
+				"{}",
+				"--ndjson-interval",
+				"1s",
+			},
+		},
+		{
+			name: "CRLFWithLF",
+			args: []string{
+				"200",
+				"OK",
+				"--crlf",
+				"--lf",
+			},
+		},
+		{
+			name: "MultipartWithoutPart",
+			args: []string{
+				"200",
+				"",
+				"--multipart",
+			},
+		},
+		{
+			name: "MultipartWithBody",
+			args: []string{
+				"200",
+				"OK",
+				"--multipart",
+				"--part",
+				"a::b",
+			},
+		},
+		{
+			name: "PartWithoutMultipart",
+			args: []string{
+				"200",
+				"",
+				"--part",
+				"a::b",
+			},
+		},
+		{
+			name: "InvalidPart",
+			args: []string{
+				"200",
+				"",
+				"--multipart",
+				"--part",
+				"a",
+			},
+		},
+		{
+			name: "RedirectPermanentWithTemporary",
+			args: []string{
+				"200",
+				"",
+				"--redirect-permanent",
+				"/a",
+				"--redirect-temporary",
+				"/b",
+			},
+		},
+		{
+			name: "RelativeStatusFromPath",
+			args: []string{
+				"--status-from-path",
+				"status",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "DualStackWithSystemd",
+			args: []string{
+				"--dual-stack",
+				"--systemd",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "EmptyBodyStatusWithoutRequireBody",
+			args: []string{
+				"200",
+				"OK",
+				"--empty-body-status",
+				"422",
+			},
+		},
+		{
+			name: "InvalidEmptyBodyStatus",
 			args: []string{
-				"-p",
-				"port",
 				"200",
 				"OK",
+				"--require-body",
+				"--empty-body-status",
+				"42",
 			},
 		},
 		{
-			name: "NoStatusCode",
-			args: []string{},
+			name: "ChaosDelayWithoutChaos",
+			args: []string{
+				"200",
+				"OK",
+				"--chaos-delay",
+				"1s",
+			},
 		},
 		{
-			name: "NoBody",
+			name: "InvalidChaos",
 			args: []string{
 				"200",
+				"OK",
+				"--chaos",
+				"normal:1,explode:1",
 			},
 		},
 		{
-			name: "InvalidRepeat",
+			name: "InvalidBodyEncoding",
 			args: []string{
 				"200",
 				"OK",
-				"-r",
-				"invalid",
+				"--body-encoding",
+				"base64",
 			},
 		},
 		{
-			name: "NegativeRepeat",
+			name: "InvalidEscapeSequence",
 			args: []string{
+				"200",
+				`\q`,
+				"--body-encoding",
+				"escaped",
+			},
+		},
+		{
+			name: "TruncatedEscapeSequence",
+			args: []string{
+				"200",
+				`ab\x0`,
+				"--body-encoding",
+				"escaped",
+			},
+		},
+		{
+			name: "NegativeIdleShutdown",
+			args: []string{
+				"--idle-shutdown",
+				"-1s",
 				"200",
 				"OK",
-				"-r",
+			},
+		},
+		{
+			name: "NegativeGzipMinSize",
+			args: []string{
+				"--gzip-min-size",
 				"-1",
+				"200",
+				"OK",
 			},
 		},
 		{
-			name: "ZeroRepeat",
+			name: "InvalidTrailerChecksum",
+			args: []string{
+				"200",
+				"OK",
+				"--trailer-checksum",
+				"crc32",
+			},
+		},
+		{
+			name: "TrailerChecksumWithCorruptLength",
+			args: []string{
+				"200",
+				"OK",
+				"--trailer-checksum",
+				"md5",
+				"--corrupt-length",
+				"10",
+			},
+		},
+		{
+			name: "NegativeListenRetry",
+			args: []string{
+				"--listen-retry",
+				"-1s",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "ConnectStatusWithConnectTunnel",
+			args: []string{
+				"--connect-status",
+				"405",
+				"--connect-tunnel",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "InformationalConnectStatus",
+			args: []string{
+				"--connect-status",
+				"100",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "InvalidHashSelect",
+			args: []string{
+				"--hash-select",
+				"header:",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "LogMaxSizeWithoutLogFile",
+			args: []string{
+				"--log-max-size",
+				"1024",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "LogFileWithSyslog",
+			args: []string{
+				"--log-file",
+				"mock.log",
+				"--syslog",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "AdminPathWithoutSlash",
+			args: []string{
+				"--admin-path",
+				"_admin",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "AdminPathSameAsControlPath",
+			args: []string{
+				"--admin-path",
+				"/_control",
+				"--control-path",
+				"/_control",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "InvalidActiveFrom",
+			args: []string{
+				"200",
+				"OK",
+				"--active-from",
+				"tomorrow",
+			},
+		},
+		{
+			name: "NegativeActiveUntil",
+			args: []string{
+				"200",
+				"OK",
+				"--active-until",
+				"-1s",
+			},
+		},
+		{
+			name: "ActiveUntilBeforeActiveFrom",
+			args: []string{
+				"200",
+				"OK",
+				"--active-from",
+				"10s",
+				"--active-until",
+				"5s",
+			},
+		},
+		{
+			name: "ObsFoldWithAlsoSend",
+			args: []string{
+				"200",
+				"OK",
+				"--obs-fold",
+				"X-Folded: a b",
+				"--also-send",
+				"1",
+			},
+		},
+		{
+			name: "ReloadFileWithoutBodyFile",
+			args: []string{
+				"200",
+				"body.txt",
+				"--reload-file",
+			},
+		},
+		{
+			name: "NegativeMaxConnsPerIP",
+			args: []string{
+				"--max-conns-per-ip",
+				"-1",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "GzipBombWithBody",
+			args: []string{
+				"200",
+				"OK",
+				"--gzip-bomb",
+				"1024",
+			},
+		},
+		{
+			name: "GzipBombWithGzip",
+			args: []string{
+				"200",
+				"",
+				"--gzip-bomb",
+				"1024",
+				"--gzip",
+			},
+		},
+		{
+			name: "NegativeMaxRequestsPerConn",
+			args: []string{
+				"--max-requests-per-conn",
+				"-1",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "InvalidMessageFile",
+			args: []string{
+				"200",
+				"--message-file",
+				"testdata/invalid_message.txt",
+			},
+		},
+		{
+			name: "MessageFileWithBody",
+			args: []string{
+				"200",
+				"OK",
+				"--message-file",
+				"testdata/message.txt",
+			},
+		},
+		{
+			name: "JitterByPathWithoutJitter",
+			args: []string{
+				"--jitter-by-path",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "CountHeaderWithIndexHeader",
+			args: []string{
+				"--count-header",
+				"X-Count",
+				"--index-header",
+				"X-Index",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "UnknownBadDigest",
+			args: []string{
+				"200",
+				"OK",
+				"--bad-digest",
+				"crc32",
+			},
+		},
+		{
+			name: "NegativeContinueDelay",
+			args: []string{
+				"--continue-delay",
+				"-1s",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "SSEKeepaliveWithoutSSE",
+			args: []string{
+				"200",
+				"OK",
+				"--sse-keepalive",
+				"1s",
+			},
+		},
+		{
+			name: "SSEKeepaliveWithoutInterval",
+			args: []string{
+				"200",
+				"data: a\n\ndata: b\n\n",
+				"--sse",
+				"--sse-keepalive",
+				"1s",
+			},
+		},
+		{
+			name: "SSEWithNDJSON",
+			args: []string{
+				"200",
+				"{}",
+				"--sse",
+				"--ndjson",
+			},
+		},
+		{
+			name: "NegativeMaxRuntime",
+			args: []string{
+				"--max-runtime",
+				"-1s",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "RefuseProbabilityOutOfRange",
+			args: []string{
+				"--refuse-probability",
+				"1.5",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "RefuseProbabilityNaN",
+			args: []string{
+				"--refuse-probability",
+				"NaN",
+				"200",
+				"OK",
+			},
+		},
+		{
+			name: "StatusOnlyWithoutAllowEmptyBody",
+			args: []string{
+				"200",
+			},
+		},
+		{
+			name: "OmittedBodyBetweenResponsesWithoutAllowEmptyBody",
 			args: []string{
 				"200",
 				"OK",
+				"204",
 				"-r",
-				"0",
+				"2",
 			},
 		},
 		{
-			name: "InvalidHeaderInGrobalOptions",
+			name: "InvalidResponseDelayFile",
 			args: []string{
-				"-H",
-				"invalid",
+				"--response-delay-file",
+				path.Join(dir, "testdata/invalid_delays.txt"),
+				"200",
+				"OK",
+				"200",
+				"OK",
 			},
 		},
 		{
-			name: "InvalidHeaderInResponseOptions",
+			name: "ResponseDelayFileCountMismatch",
 			args: []string{
+				"--response-delay-file",
+				path.Join(dir, "testdata/delays.txt"),
 				"200",
 				"OK",
-				"-H",
-				"invalid",
+				"-r",
+				"3",
 			},
 		},
 	}
@@ -291,6 +1747,65 @@ func TestParseArgsFailure(t *testing.T) {
 	}
 }
 
+func TestParseArgsBodyLimit(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1<<20)
+	file := path.Join(t.TempDir(), "large.txt")
+	if err := os.WriteFile(file, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		limit  string
+		expect []byte
+	}{
+		{limit: "15", expect: content[:15]},
+		{limit: strconv.Itoa(len(content) + 1), expect: content},
+	}
+
+	for _, c := range cases {
+		s, err := parseArgs([]string{"200", file, "--body-file", "--body-limit", c.limit})
+		if err != nil {
+			t.Fatalf("error was not expected but got: %#v", err)
+		}
+		if !bytes.Equal(s.responses[0].body, c.expect) {
+			t.Errorf("limit %s: expect %d bytes, but got %d bytes", c.limit, len(c.expect), len(s.responses[0].body))
+		}
+	}
+}
+
+func TestParseArgsRandomBody(t *testing.T) {
+	parseBody := func(args ...string) []byte {
+		t.Helper()
+		s, err := parseArgs(args)
+		if err != nil {
+			t.Fatalf("error was not expected but got: %#v", err)
+		}
+		if len(s.responses) != 1 {
+			t.Fatalf("1 response is expected but got %d", len(s.responses))
+		}
+		return s.responses[0].body
+	}
+
+	seeded1 := parseBody("--seed", "42", "200", "ignored", "--random-body", "1024")
+	seeded2 := parseBody("--seed", "42", "200", "ignored", "--random-body", "1024")
+	if len(seeded1) != 1024 {
+		t.Errorf("body length: expect 1024, but got %d", len(seeded1))
+	}
+	if !bytes.Equal(seeded1, seeded2) {
+		t.Error("bodies with the same seed should be identical")
+	}
+
+	otherSeed := parseBody("--seed", "43", "200", "ignored", "--random-body", "1024")
+	if bytes.Equal(seeded1, otherSeed) {
+		t.Error("bodies with different seeds should differ")
+	}
+
+	unseeded := parseBody("200", "ignored", "--random-body", "16")
+	if len(unseeded) != 16 {
+		t.Errorf("body length: expect 16, but got %d", len(unseeded))
+	}
+}
+
 func TestParseArgsHelpOption(t *testing.T) {
 	cases := []struct {
 		name string
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// planHeaders are the headers shown in the plan.
+var planHeaders = []string{"Content-Type", "Content-Encoding", "Location"}
+
+// printPlan writes a table of the responses in the order they are served.
+// Responses served by --always are listed after the sequence with their path as the index,
+// in the order they are served in turn if several share the path.
+func (h *handler) printPlan(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tSTATUS\tBYTES\tHEADERS")
+	for i, r := range h.responses {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%s\n", i, r.statusCode, len(r.body), summarizeHeaders(r))
+	}
+
+	paths := make([]string, 0, len(h.always))
+	for path := range h.always {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		for _, r := range h.always[path] {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", path, r.statusCode, len(r.body), summarizeHeaders(r))
+		}
+	}
+	return tw.Flush()
+}
+
+// summarizeHeaders returns the planHeaders of the response set.
+func summarizeHeaders(r *response) string {
+	hs := []string{}
+	for _, name := range planHeaders {
+		if v := r.headers.Get(name); v != "" {
+			hs = append(hs, fmt.Sprintf("%s=%q", name, v))
+		}
+	}
+	return strings.Join(hs, " ")
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHandlerPrintPlan(t *testing.T) {
+	sc, err := parseArgs([]string{
+		"--order", "reverse",
+		"200", "OK", "-r", "2", "-H", "Content-Type: text/plain",
+		"404", "missing",
+		"204", "", "--always", "/health",
+	})
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	h := newHandler(sc, func() {})
+
+	out := &bytes.Buffer{}
+	if err := h.printPlan(out); err != nil {
+		t.Fatalf("printPlan failed: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	expect := [][]string{
+		{"INDEX", "STATUS", "BYTES", "HEADERS"},
+		{"0", "404", "7"},
+		{"1", "200", "2", `Content-Type="text/plain"`},
+		{"2", "200", "2", `Content-Type="text/plain"`},
+		{"/health", "204", "0"},
+	}
+	if len(lines) != len(expect) {
+		t.Fatalf("expect %d lines, but got %d: %q", len(expect), len(lines), out.String())
+	}
+	for i, line := range lines {
+		if fields := strings.Fields(line); strings.Join(fields, " ") != strings.Join(expect[i], " ") {
+			t.Errorf("line %d: expect %q, but got %q", i, expect[i], fields)
+		}
+	}
+}
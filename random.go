@@ -0,0 +1,25 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// randomSource returns the source of random bodies.
+// It is cryptographically random unless the seed is set.
+func randomSource(seed *int64) io.Reader {
+	if seed == nil {
+		return crand.Reader
+	}
+	return rand.New(rand.NewSource(*seed))
+}
+
+// newRand returns a random generator from the seed, or from the current time if the seed is nil.
+func newRand(seed *int64) *rand.Rand {
+	if seed == nil {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return rand.New(rand.NewSource(*seed))
+}
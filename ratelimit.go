@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitConfig allows count requests per interval.
+type rateLimitConfig struct {
+	count    int
+	interval time.Duration
+}
+
+// parseRateLimit parses <count>/<interval> like "10/s" or "5/500ms".
+func parseRateLimit(s string) (*rateLimitConfig, error) {
+	countStr, intervalStr, ok := strings.Cut(s, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid rate limit: %q", s)
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit: %q", s)
+	}
+	if count <= 0 {
+		return nil, errors.New("rate limit count must be positive")
+	}
+	if intervalStr != "" && (intervalStr[0] < '0' || intervalStr[0] > '9') {
+		intervalStr = "1" + intervalStr
+	}
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit: %q", s)
+	}
+	if interval <= 0 {
+		return nil, errors.New("rate limit interval must be positive")
+	}
+	return &rateLimitConfig{count: count, interval: interval}, nil
+}
+
+// tokenBucket is a token bucket holding up to capacity tokens which refills at rate.
+type tokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	// rate is the number of tokens refilled per second.
+	rate float64
+	last time.Time
+	now  func() time.Time
+}
+
+func newTokenBucket(c *rateLimitConfig) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(c.count),
+		tokens:   float64(c.count),
+		rate:     float64(c.count) / c.interval.Seconds(),
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// take takes a token if available.
+// Otherwise it returns false and the time until a token is available.
+func (b *tokenBucket) take() (ok bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	cases := []struct {
+		arg    string
+		expect *rateLimitConfig
+	}{
+		{arg: "10/s", expect: &rateLimitConfig{count: 10, interval: time.Second}},
+		{arg: "100/m", expect: &rateLimitConfig{count: 100, interval: time.Minute}},
+		{arg: "5/500ms", expect: &rateLimitConfig{count: 5, interval: 500 * time.Millisecond}},
+		{arg: "10"},
+		{arg: "ten/s"},
+		{arg: "0/s"},
+		{arg: "10/"},
+		{arg: "10/0s"},
+		{arg: "10/x"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.arg, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := parseRateLimit(c.arg)
+			if c.expect == nil {
+				if err == nil {
+					t.Errorf("error was expected but got %#v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error was not expected but got: %#v", err)
+			}
+			if !reflect.DeepEqual(actual, c.expect) {
+				t.Errorf("expect %#v, but got %#v", c.expect, actual)
+			}
+		})
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(&rateLimitConfig{count: 2, interval: time.Second})
+	b.last = now
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.take(); !ok {
+			t.Fatalf("%d-th token should be available", i)
+		}
+	}
+	ok, retryAfter := b.take()
+	if ok {
+		t.Fatal("token should not be available after burst")
+	}
+	if retryAfter != 500*time.Millisecond {
+		t.Errorf("retry after: expect %s, but got %s", 500*time.Millisecond, retryAfter)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := b.take(); !ok {
+		t.Error("token should be refilled")
+	}
+	if ok, _ := b.take(); ok {
+		t.Error("only one token should be refilled")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// reloadError is the error reading the body file of a response reloaded per request.
+type reloadError struct {
+	err error
+}
+
+func (e *reloadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *reloadError) Unwrap() error {
+	return e.err
+}
+
+// reloadBody reads the body file of up to limit bytes, or entirely if limit is zero.
+func reloadBody(file string, limit int) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, &reloadError{err}
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if limit > 0 {
+		r = io.LimitReader(f, int64(limit))
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &reloadError{err}
+	}
+	return b, nil
+}
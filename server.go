@@ -1,13 +1,29 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"text/template"
+	"time"
 )
 
 type serverConfig struct {
@@ -15,35 +31,333 @@ type serverConfig struct {
 	headers   http.Header
 	responses []*responseConfig
 	tls       *tlsConfig
+	// httpsAddr is the address to serve TLS on in addition to serving plaintext on addr.
+	httpsAddr string
+	// systemd uses the socket passed by systemd instead of binding addr.
+	systemd bool
+	// dualStack listens on IPv4 and IPv6 with separate listeners of the same port.
+	dualStack bool
+	// listenRetry is how long binding is retried while the address is in use.
+	listenRetry time.Duration
+	// openAPI is the example responses of the operations of an OpenAPI spec served out of the sequence.
+	openAPI []*openAPIRoute
+	// roundRobinPath serves all responses with the same --always path in turn
+	// instead of only the last one.
+	roundRobinPath bool
+	// responseDelays is the delay before sending each response of the sequence.
+	responseDelays []time.Duration
+	// bodyPrefix and bodySuffix wrap the body of every response.
+	bodyPrefix []byte
+	bodySuffix []byte
+	// indexHeader is the request header selecting the response by its index.
+	indexHeader string
+	// crashOnRequest is the ordinal of the request on which the process crashes.
+	crashOnRequest int
+	// timeFormat is the format of the timestamp of logs.
+	timeFormat string
+	// delayRamp is the delay added per request served before.
+	delayRamp time.Duration
+	// jitter is the maximum random delay added to each response. Zero disables it.
+	jitter time.Duration
+	// jitterByPath derives the jitter from the hash of the request path and the seed.
+	jitterByPath bool
+	// mergeHeaders joins multiple values of a header into a single line.
+	mergeHeaders bool
+	// announceJSON prints the bound address as JSON once listening.
+	announceJSON bool
+	// notFoundTemplate renders the body of 404 responses to requests no response is left for.
+	notFoundTemplate *template.Template
+	// rateLimit limits the rate of requests served.
+	rateLimit *rateLimitConfig
+	// shutdownWebhook is the URL notified of the shutdown.
+	shutdownWebhook string
+	// gzipLevel is the compression level of gzip. Zero means the default level.
+	gzipLevel int
+	// gzipMinSize is the body size below which gzip is not applied. Zero compresses all bodies.
+	gzipMinSize int
+	// seed is the seed of random values. If nil, values are not reproducible.
+	seed *int64
+	// logSummary logs a summary of requests instead of dumping them.
+	logSummary bool
+	// acceptDelay is the delay before each accepted connection is served.
+	acceptDelay time.Duration
+	// badRequestBody replaces the body of the 400 responses to malformed requests on plain HTTP/1.x.
+	// Empty leaves the body of net/http.
+	badRequestBody string
+	// controlPath is the path to control the sequence of responses.
+	controlPath string
+	// order is the order responses are served in: "sequential", "reverse" or "shuffle".
+	// Empty means "sequential".
+	order string
+	// requestReadTimeout is the timeout to read the request body.
+	requestReadTimeout time.Duration
+	// syslog sends logs to syslog instead of stdout and stderr if not nil.
+	syslog *syslogConfig
+	// honorMethodOverride uses X-HTTP-Method-Override as the method of requests.
+	honorMethodOverride bool
+	// printPlan prints the table of responses to stderr before serving.
+	printPlan bool
+	// startupDelay is the delay after binding before serving any connection.
+	startupDelay time.Duration
+	// refuseProbability is the probability of closing accepted connections without serving them.
+	refuseProbability float64
+	// maxConnsPerIP is the number of connections open at once from each remote IP.
+	// Connections beyond it are closed without serving them. Zero disables it.
+	maxConnsPerIP int
+	// delayFromHeader is the request header whose duration delays the response.
+	delayFromHeader string
+	// readBuffer and writeBuffer are the socket buffer sizes of accepted connections.
+	readBuffer  int
+	writeBuffer int
+	// logCurl logs requests as curl commands reproducing them.
+	logCurl bool
+	// enableTrace echoes TRACE requests out of the sequence.
+	enableTrace bool
+	// statusFromPath is the path whose next segment is the status code to respond out of the sequence.
+	statusFromPath string
+	// preferAsync answers requests with Prefer: respond-async by 202 and serves their responses
+	// from the status URLs.
+	preferAsync bool
+	// allowEmptyBody allows omitting <body> for an empty body.
+	allowEmptyBody bool
+	// echoClientCert adds the verified client certificate to the response headers.
+	echoClientCert bool
+	// reflectHeaders is the prefix of the names of request headers copied to the response.
+	reflectHeaders string
+	// templateErrorBody is the body of 500 responded when a body template fails to render.
+	// If empty, the status text is used.
+	templateErrorBody string
+	// ignoreFavicon responds 204 to /favicon.ico without logging or counting the request.
+	ignoreFavicon bool
+	// noShutdown keeps serving the last response after the sequence instead of shutting down.
+	noShutdown bool
+	// loop serves the sequence from the first again after the last, forever.
+	loop bool
+	// idleShutdown shuts down the server if no request arrives for the duration. Zero disables it.
+	idleShutdown time.Duration
+	// maxRuntime shuts down the server once it has run for the duration, whatever it serves.
+	// Zero disables it.
+	maxRuntime time.Duration
+	// continueDelay delays the 100 Continue to requests with "Expect: 100-continue".
+	continueDelay time.Duration
+	// maxRequestsPerConn is the number of requests served on a connection before it is closed.
+	// Zero disables it.
+	maxRequestsPerConn int
+	// timingLog logs the time spent in the delay, in writing the body and in total per request.
+	timingLog bool
+	// connectStatus is the status answering CONNECT requests out of the sequence if not zero.
+	// connectTunnel relays CONNECT requests to the requested host instead.
+	connectStatus int
+	connectTunnel bool
+	// hashSelect is the request attribute whose hash picks the response out of the sequence:
+	// "path", "remote-addr" or "header:<name>". Empty disables it.
+	hashSelect string
+	// countHeader is the request header with the counter selecting the response, which must
+	// increment by one from 1 on every request. Empty disables it.
+	countHeader string
+	// adminPath is the path of the page showing the sequence. Empty disables it.
+	adminPath string
+	// requestQuota is the number of requests served before responding 429 to all requests.
+	requestQuota int
+	// logFile is the file logs are written to instead of stdout and stderr if not empty.
+	// It is rotated when it would exceed logMaxSize bytes unless zero.
+	logFile    string
+	logMaxSize int64
 }
 
 type responseConfig struct {
 	statusCode int
 	body       []byte
 	headers    http.Header
+	// methodBodies is the body by request method used instead of body.
+	methodBodies map[string][]byte
+	// corruptLength is the number of bytes Content-Length claims beyond the body.
+	corruptLength int
+	// gzip compresses the body with gzip.
+	gzip bool
+	// brotli encodes the body with brotli.
+	brotli bool
+	// alwaysPath makes the response served to every request to the path
+	// instead of being a part of the sequence.
+	alwaysPath string
+	// untilSignal serves the response to every request once reached, without shutting down.
+	untilSignal bool
+	// grpcWeb frames the body as a gRPC-Web response. grpcWebText also base64 encodes it.
+	grpcWeb     bool
+	grpcWebText bool
+	// matchCookie is the cookie requests must have to be served the response if not nil.
+	matchCookie *http.Cookie
+	// requireTLS serves the response only to requests over TLS.
+	requireTLS bool
+	// requireBody is the status responded to requests without a body instead of the response
+	// if not zero. The response is left for a later request with a body.
+	requireBody int
+	// trailerChecksum sends the body chunked followed by its Content-Digest by the algorithm
+	// in the trailer if not empty.
+	trailerChecksum string
+	// badDigest sets Content-Digest and Digest by the algorithm not matching the body if not empty.
+	badDigest string
+	// chaos is the weights of the behaviors randomly applied to each request if not nil.
+	// The delay behavior adds chaosDelay.
+	chaos      []chaosWeight
+	chaosDelay time.Duration
+	// activeFrom and activeUntil bound the time window the response is served in if not nil.
+	activeFrom  *activeTime
+	activeUntil *activeTime
+	// bodyTemplate renders the body per request instead of body if not nil.
+	bodyTemplate *template.Template
+	// gzipBomb is the size body decompresses to if not zero. body is the gzip stream then,
+	// which is served without the body prefix and suffix.
+	gzipBomb int64
+	// reloadFile is the body file read on every request instead of body if not empty,
+	// up to reloadLimit bytes if not zero.
+	reloadFile  string
+	reloadLimit int
+	// ndjson streams the lines of the body with ndjsonInterval between them.
+	ndjson         bool
+	ndjsonInterval time.Duration
+	// sse streams the lines of the body as server-sent events with sseInterval between them,
+	// writing a comment every sseKeepalive while waiting if not zero.
+	sse          bool
+	sseInterval  time.Duration
+	sseKeepalive time.Duration
+	// matchLanguage is the language tag Accept-Language of requests must accept
+	// to be served the response if not empty.
+	matchLanguage string
+	// method is the method of requests served the response if not empty, in upper case.
+	// Responses without it match any method.
+	method string
+	// alsoSend is the number of extra copies of the response written on the connection after it.
+	alsoSend int
+	// obsFold is the headers written with the obsolete line folding if not nil.
+	obsFold http.Header
+	// lineEnding converts the line endings of the body to "crlf" or "lf". Empty keeps them.
+	lineEnding string
+	// multipartParts are assembled into a multipart/form-data body instead of body if not nil.
+	multipartParts []multipartPart
+}
+
+type syslogConfig struct {
+	// network and addr are of the syslog server. Empty addr means the local syslog server.
+	network string
+	addr    string
 }
 
 type tlsConfig struct {
 	certFile string
 	keyFile  string
+	// handshakeDelay is the delay before the TLS handshake of each connection.
+	handshakeDelay time.Duration
+	// alpn is the protocols negotiated by ALPN in order of preference. If nil, Go's defaults are used.
+	alpn []string
+	// clientCAs verifies client certificates if not nil. Clients without certificates are still accepted.
+	clientCAs *x509.CertPool
 }
 
 type response struct {
 	statusCode int
 	body       []byte
 	headers    http.Header
+	delay      time.Duration
+	// methodBodies is the body by request method used instead of body.
+	methodBodies map[string][]byte
+	// bodyIgnored reports whether a body was configured for a status that cannot have one.
+	// The body is not sent.
+	bodyIgnored bool
+	// corruptLength is the number of bytes Content-Length claims beyond the body.
+	corruptLength int
+	// untilSignal serves the response to every request once reached, without shutting down.
+	untilSignal bool
+	// matchCookie is the cookie requests must have to be served the response if not nil.
+	matchCookie *http.Cookie
+	// requireTLS serves the response only to requests over TLS.
+	requireTLS bool
+	// requireBody is the status responded to requests without a body instead of the response
+	// if not zero. The response is left for a later request with a body.
+	requireBody int
+	// trailerChecksum sends the body chunked followed by its Content-Digest by the algorithm
+	// in the trailer if not empty.
+	trailerChecksum string
+	// badDigest sets Content-Digest and Digest by the algorithm not matching the body if not empty.
+	badDigest string
+	// chaos is the weights of the behaviors randomly applied to each request if not nil.
+	// The delay behavior adds chaosDelay.
+	chaos      []chaosWeight
+	chaosDelay time.Duration
+	// activeFrom and activeUntil bound the time window the response is served in.
+	// Outside it, the response is skipped as if it did not match. Zero means unbounded.
+	activeFrom  time.Time
+	activeUntil time.Time
+	// gzipMinSize compresses bodies of at least the size with gzipLevel when served if not zero.
+	// The body is stored uncompressed then.
+	gzipMinSize int
+	gzipLevel   int
+	// render renders the body per request instead of body if not nil.
+	render func(*requestData) ([]byte, error)
+	// ndjson streams the lines of the body with ndjsonInterval between them.
+	ndjson         bool
+	ndjsonInterval time.Duration
+	// sse streams the lines of the body as server-sent events with sseInterval between them,
+	// writing a comment every sseKeepalive while waiting if not zero.
+	sse          bool
+	sseInterval  time.Duration
+	sseKeepalive time.Duration
+	// matchLanguage is the language tag Accept-Language of requests must accept
+	// to be served the response if not empty.
+	matchLanguage string
+	// method is the method of requests served the response if not empty, in upper case.
+	// Responses without it match any method.
+	method string
+	// alsoSend is the number of extra copies of the response written on the connection after it.
+	alsoSend int
+	// obsFold is the headers written with the obsolete line folding if not nil.
+	obsFold http.Header
 }
 
 type logger struct {
 	mu sync.Mutex
+	// out and errOut are where logs are written. If nil, os.Stdout and os.Stderr are used.
+	out    io.Writer
+	errOut io.Writer
+	// timeFormat is the format of the timestamp prefixed to each log.
+	// It is "rfc3339", "unix" or a layout of time.Format. Empty disables the timestamp.
+	timeFormat string
 }
 
 func (l *logger) log(w io.Writer, msg string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.timeFormat != "" {
+		msg = formatTime(time.Now(), l.timeFormat) + " " + msg
+	}
 	fmt.Fprintln(w, msg)
 }
 
+func (l *logger) stdout() io.Writer {
+	if l.out == nil {
+		return os.Stdout
+	}
+	return l.out
+}
+
+func (l *logger) stderr() io.Writer {
+	if l.errOut == nil {
+		return os.Stderr
+	}
+	return l.errOut
+}
+
+func formatTime(t time.Time, format string) string {
+	switch format {
+	case "rfc3339":
+		return t.Format(time.RFC3339)
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	default:
+		return t.Format(format)
+	}
+}
+
 type handler struct {
 	mu        sync.Mutex
 	logger    logger
@@ -52,75 +366,1195 @@ type handler struct {
 	shutdownServer func()
 	// pos is the index of the next response.
 	pos int
+	// servedAhead is the indexes after pos of the responses already served
+	// to requests the responses before them did not match.
+	servedAhead map[int]bool
+	// indexHeader is the request header selecting the response by its index.
+	indexHeader string
+	// requestCount is the number of requests received.
+	requestCount int
+	// servedCount is the number of requests served a response, rejected ones excluded.
+	servedCount int
+	// crashOnRequest is the ordinal of the request on which the process exits abruptly
+	// to simulate a crash. Zero disables it.
+	crashOnRequest int
+	// delayRamp is the delay added per request served before.
+	delayRamp time.Duration
+	// jitter is the maximum random delay added to each response. Zero disables it.
+	// It is drawn from jitterRand, or from the hash of the path and jitterSeed with jitterByPath.
+	jitter       time.Duration
+	jitterByPath bool
+	jitterRand   *rand.Rand
+	jitterSeed   int64
+	// mergeHeaders joins multiple values of a header into a single line.
+	mergeHeaders bool
+	// notFoundTemplate renders the body of 404 responses to requests no response is left for.
+	// If nil, such requests are aborted.
+	notFoundTemplate *template.Template
+	// rateLimiter rejects requests exceeding the rate limit if not nil.
+	rateLimiter *tokenBucket
+	// logSummary logs a summary of requests instead of dumping them.
+	logSummary bool
+	// controlPath is the path to control the sequence of responses. Empty disables it.
+	controlPath string
+	// always is the responses served to every request to the path, out of the sequence,
+	// in turn from alwaysNext guarded by mu. There is one per path unless roundRobinPath.
+	always     map[string][]*response
+	alwaysNext map[string]int
+	// openAPI is the operations whose example responses are served out of the sequence.
+	openAPI []*openAPIOperation
+	// requestReadTimeout is the timeout to read the request body. Zero disables it.
+	requestReadTimeout time.Duration
+	// honorMethodOverride uses X-HTTP-Method-Override as the method of requests.
+	honorMethodOverride bool
+	// echoClientCert adds the verified client certificate to the response headers.
+	echoClientCert bool
+	// reflectHeaders is the prefix of the names of request headers copied to the response.
+	reflectHeaders string
+	// templateErrorBody is the body of 500 responded when a body template fails to render.
+	// If empty, the status text is used.
+	templateErrorBody string
+	// ignoreFavicon responds 204 to /favicon.ico without logging or counting the request.
+	ignoreFavicon bool
+	// noShutdown serves the last response again once the sequence is complete.
+	noShutdown bool
+	// loop wraps pos back to the first response after the last, so the sequence never completes.
+	loop bool
+	// idleShutdown is the duration without requests after which idleTimer shuts down the server.
+	idleShutdown time.Duration
+	idleTimer    *time.Timer
+	// continueDelay delays the 100 Continue to requests with "Expect: 100-continue".
+	continueDelay time.Duration
+	// maxRequestsPerConn is the number of requests served on a connection before it is closed.
+	maxRequestsPerConn int
+	// timingLog logs the time spent in the delay, in writing the body and in total per request.
+	timingLog bool
+	// connectStatus is the status answering CONNECT requests out of the sequence if not zero.
+	// connectTunnel relays CONNECT requests to the requested host instead.
+	connectStatus int
+	connectTunnel bool
+	// hashSelect is the request attribute whose hash picks the response out of the sequence:
+	// "path", "remote-addr" or "header:<name>". Empty disables it.
+	hashSelect string
+	// countHeader is the request header with the counter selecting the response.
+	// lastCount is the last counter accepted, guarded by mu.
+	countHeader string
+	lastCount   int
+	// adminPath is the path of the page showing the sequence. Empty disables it.
+	adminPath string
+	// now returns the current time to decide the active responses. Nil means time.Now.
+	now func() time.Time
+	// requestQuota is the number of requests served before responding 429 to all requests
+	// until the sequence is reset. Zero disables it.
+	requestQuota int
+	// quotaUsed is the number of requests counted against requestQuota.
+	quotaUsed int
+	// resumeCh is closed when the handler paused by the control path is resumed.
+	// It is nil unless paused.
+	resumeCh chan struct{}
+	// delayFromHeader is the request header whose duration delays the response. Empty disables it.
+	delayFromHeader string
+	// logCurl logs requests as curl commands reproducing them.
+	logCurl bool
+	// enableTrace echoes TRACE requests out of the sequence.
+	enableTrace bool
+	// statusFromPath is the path whose next segment is the status code to respond out of the sequence.
+	statusFromPath string
+	// statusResponse returns the response of the status from statusFromPath.
+	statusResponse func(code int) *response
+	// preferAsync answers requests with Prefer: respond-async by 202 and serves their responses
+	// from the status URLs.
+	preferAsync bool
+	// asyncResults is the responses held for the requests served asynchronously by status URL id.
+	asyncResults map[string]*response
+	// asyncDone is whether the last response of the sequence is held.
+	asyncDone bool
+	// asyncCount is the number of requests served asynchronously, used for ids.
+	asyncCount int
+	// chaosRand picks the behaviors of responses with chaos.
+	chaosRand *rand.Rand
 }
 
 type server struct {
 	*http.Server
 	shutdownCh chan error
+	handler    *handler
+	// shutdownWebhook is the URL notified of the shutdown.
+	shutdownWebhook string
+	// startupDelay is the delay before serving any connection.
+	startupDelay time.Duration
+	// maxRuntime is the duration after which the server is shut down. Zero disables it.
+	maxRuntime time.Duration
+
+	shutdownOnce   sync.Once
+	shutdownReason string
+}
+
+// serveAll serves on all the endpoints until the server is shut down or fails.
+// All endpoints share the handler and thus the sequence of responses.
+func (s *server) serveAll(endpoints []endpoint, c *tlsConfig) error {
+	if s.maxRuntime > 0 {
+		// the startup delay counts as running
+		time.AfterFunc(s.maxRuntime, s.shutdownAfterMaxRuntime)
+	}
+	// connections made meanwhile wait in the backlog of the listeners
+	time.Sleep(s.startupDelay)
+	s.handler.startIdleTimer(func() { s.shutdown("idle") })
+
+	errCh := make(chan error, len(endpoints))
+	for _, e := range endpoints {
+		go func(e endpoint) {
+			if e.tls {
+				errCh <- s.ServeTLS(e, c.certFile, c.keyFile)
+			} else {
+				errCh <- s.Serve(e)
+			}
+		}(e)
+	}
+	return <-errCh
+}
+
+// shutdown shuts down the server gracefully.
+// Only the first call takes effect.
+func (s *server) shutdown(reason string) {
+	s.shutdownOnce.Do(func() {
+		s.shutdownReason = reason
+		s.shutdownCh <- s.Shutdown(context.Background())
+	})
+}
+
+// maxRuntimeGrace is how long the responses in progress may take to finish after the max runtime.
+const maxRuntimeGrace = time.Second
+
+// shutdownAfterMaxRuntime shuts down the server, closing the connections still active
+// after maxRuntimeGrace so that hanging responses cannot keep it running.
+func (s *server) shutdownAfterMaxRuntime() {
+	// a shutdown already in progress may be stuck on them too
+	time.AfterFunc(maxRuntimeGrace, func() { s.Close() })
+	s.shutdown("max runtime")
 }
 
 func (s *server) waitForShutDown() {
 	<-s.shutdownCh
+	if s.shutdownWebhook != "" {
+		s.notifyShutdown()
+	}
+}
+
+// shutdownNotification is the payload posted to the shutdown webhook.
+type shutdownNotification struct {
+	TotalRequests int    `json:"total_requests"`
+	Reason        string `json:"reason"`
+}
+
+const shutdownWebhookTimeout = 3 * time.Second
+
+// notifyShutdown posts the shutdown to the webhook.
+// Failures are only logged since the server is already shut down.
+func (s *server) notifyShutdown() {
+	s.handler.mu.Lock()
+	n := shutdownNotification{
+		TotalRequests: s.handler.requestCount,
+		Reason:        s.shutdownReason,
+	}
+	s.handler.mu.Unlock()
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		s.handler.logger.log(s.handler.logger.stderr(), fmt.Sprintf("Failed to notify shutdown: %v", err))
+		return
+	}
+	client := &http.Client{Timeout: shutdownWebhookTimeout}
+	resp, err := client.Post(s.shutdownWebhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		s.handler.logger.log(s.handler.logger.stderr(), fmt.Sprintf("Failed to notify shutdown: %v", err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		s.handler.logger.log(s.handler.logger.stderr(), fmt.Sprintf("Failed to notify shutdown: %s", resp.Status))
+	}
 }
 
-// getResponse returns the next response and wheather the response is the last if such a response exists,
-// or nil, false if all responses were used.
-func (h *handler) getResponse() (resp *response, isLast bool) {
+// getResponse returns the next response matching the request and wheather the response is the last
+// if such a response exists, or nil, false if no response is left for the request.
+// If r is nil, the next response is returned regardless of its constraints.
+func (h *handler) getResponse(r *http.Request) (resp *response, isLast bool) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	i := h.pos
-	if i < len(h.responses) {
+	i := h.nextMatch(r)
+	if i < 0 {
+		if h.noShutdown && len(h.responses) > 0 && h.pos >= len(h.responses) {
+			if last := h.responses[len(h.responses)-1]; r == nil || last.matches(r, h.effectiveMethod(r)) {
+				return last, false
+			}
+		}
+		return nil, false
+	}
+	if h.responses[i].untilSignal {
+		// the sequence stays here until the server is stopped by a signal
+		return h.responses[i], false
+	}
+	if i < h.pos {
+		// in a loop, nothing is left for the request in this cycle; start the next one from it
+		h.pos = i
+		h.servedAhead = nil
+	}
+	if i > h.pos {
+		// served out of order since the responses before it do not match the request
+		if h.servedAhead == nil {
+			h.servedAhead = map[int]bool{}
+		}
+		h.servedAhead[i] = true
+		return h.responses[i], false
+	}
+	h.pos++
+	for h.servedAhead[h.pos] {
+		delete(h.servedAhead, h.pos)
 		h.pos++
-		return h.responses[i], h.pos >= len(h.responses)
 	}
-	return nil, false
+	if h.loop {
+		h.pos %= len(h.responses)
+		return h.responses[i], false
+	}
+	return h.responses[i], h.pos >= len(h.responses)
+}
+
+// nextMatch returns the index of the first response left matching the request, or -1 if none.
+// In a loop, the responses before pos are searched after the rest as the next cycle.
+// If the response has a language constraint, a later one matching the language better is preferred.
+// The caller must hold h.mu.
+func (h *handler) nextMatch(r *http.Request) int {
+	best, bestQuality := -1, 0.0
+	now := h.clock()
+	method := ""
+	if r != nil {
+		method = h.effectiveMethod(r)
+	}
+	n := len(h.responses) - h.pos
+	if h.loop {
+		// the responses before pos are the next cycle
+		n = len(h.responses)
+	}
+	for k := 0; k < n; k++ {
+		i := (h.pos + k) % len(h.responses)
+		if h.servedAhead[i] || !h.responses[i].active(now) || (r != nil && !h.responses[i].matches(r, method)) {
+			continue
+		}
+		lang := h.responses[i].matchLanguage
+		if best < 0 {
+			if r == nil || lang == "" {
+				return i
+			}
+			best, bestQuality = i, languageQuality(r.Header.Get("Accept-Language"), lang)
+		} else if lang != "" {
+			if q := languageQuality(r.Header.Get("Accept-Language"), lang); q > bestQuality {
+				best, bestQuality = i, q
+			}
+		}
+	}
+	return best
+}
+
+// leftForTLS reports whether any response requiring TLS is left in the sequence.
+func (h *handler) leftForTLS() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := h.pos; i < len(h.responses); i++ {
+		if !h.servedAhead[i] && h.responses[i].requireTLS {
+			return true
+		}
+	}
+	return false
+}
+
+// nextRequiresBody returns the requireBody of the response the request would be served next,
+// or zero if none is left.
+func (h *handler) nextRequiresBody(r *http.Request) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := h.nextMatch(r)
+	if i < 0 {
+		return 0
+	}
+	return h.responses[i].requireBody
+}
+
+// hasBody reports whether the request has a non-empty body.
+// If the length is unknown, the first byte of the body is read ahead.
+func hasBody(r *http.Request) bool {
+	if r.ContentLength >= 0 {
+		return r.ContentLength > 0
+	}
+	br := bufio.NewReader(r.Body)
+	_, err := br.Peek(1)
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{br, r.Body}
+	return err == nil
+}
+
+// matches reports whether the request served as method satisfies the constraints of the response.
+func (r *response) matches(req *http.Request, method string) bool {
+	if r.requireTLS && req.TLS == nil {
+		return false
+	}
+	if r.method != "" && !strings.EqualFold(method, r.method) && !(method == http.MethodHead && r.method == http.MethodGet) {
+		// HEAD requests are served the responses to GET as net/http does
+		return false
+	}
+	if r.matchLanguage != "" && languageQuality(req.Header.Get("Accept-Language"), r.matchLanguage) <= 0 {
+		return false
+	}
+	if r.matchCookie != nil {
+		c, err := req.Cookie(r.matchCookie.Name)
+		if err != nil || c.Value != r.matchCookie.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// responseAt returns the response at the index, or nil if the index is invalid.
+// It does not advance the sequence.
+func (h *handler) responseAt(index string) *response {
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(h.responses) {
+		return nil
+	}
+	return h.responses[i]
+}
+
+// alwaysFor returns the next of the responses served to every request to the path in turn,
+// or nil if there is none.
+func (h *handler) alwaysFor(path string) *response {
+	resps := h.always[path]
+	switch len(resps) {
+	case 0:
+		return nil
+	case 1:
+		return resps[0]
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.alwaysNext == nil {
+		h.alwaysNext = map[string]int{}
+	}
+	i := h.alwaysNext[path]
+	h.alwaysNext[path] = (i + 1) % len(resps)
+	return resps[i]
+}
+
+// hashResponse returns the response at the index of the hash of the hashSelect attribute
+// of the request. It does not advance the sequence.
+func (h *handler) hashResponse(r *http.Request) *response {
+	var attr string
+	switch {
+	case h.hashSelect == "path":
+		attr = r.URL.Path
+	case h.hashSelect == "remote-addr":
+		// the port differs per connection
+		attr, _, _ = net.SplitHostPort(r.RemoteAddr)
+	default:
+		attr = r.Header.Get(strings.TrimPrefix(h.hashSelect, "header:"))
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(attr))
+	return h.responses[hash.Sum32()%uint32(len(h.responses))]
+}
+
+// takeQuota counts the request against requestQuota and reports whether it is within the quota.
+func (h *handler) takeQuota() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.quotaUsed >= h.requestQuota {
+		return false
+	}
+	h.quotaUsed++
+	return true
+}
+
+// startIdleTimer calls shutdown once no request arrives for idleShutdown, if set.
+func (h *handler) startIdleTimer(shutdown func()) {
+	if h.idleShutdown <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.idleTimer = time.AfterFunc(h.idleShutdown, shutdown)
+}
+
+// resetIdleTimer restarts the idle period on a request.
+func (h *handler) resetIdleTimer() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.idleTimer != nil {
+		h.idleTimer.Reset(h.idleShutdown)
+	}
+}
+
+// countServed counts the request served a response and returns its ordinal.
+func (h *handler) countServed() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.servedCount++
+	return h.servedCount
+}
+
+// countRequest counts the received request and returns its ordinal.
+func (h *handler) countRequest() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requestCount++
+	return h.requestCount
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	resp, isLast := h.getResponse()
-	if resp == nil {
+	start := time.Now()
+	h.resetIdleTimer()
+	if h.maxRequestsPerConn > 0 {
+		h.closeAfterMaxRequests(w, r)
+	}
+
+	if h.controlPath != "" && r.URL.Path == h.controlPath {
+		h.serveControl(w, r)
+		return
+	}
+	if h.adminPath != "" && r.URL.Path == h.adminPath {
+		h.serveAdmin(w, r)
+		return
+	}
+
+	if h.ignoreFavicon && r.URL.Path == "/favicon.ico" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !h.waitResumed(r) {
+		return
+	}
+
+	if r.Method == http.MethodConnect && (h.connectStatus != 0 || h.connectTunnel) {
+		h.logRequest(r)
+		h.serveConnect(w, r)
+		return
+	}
+
+	if h.enableTrace && r.Method == http.MethodTrace {
+		h.logRequest(r)
+		h.serveTrace(w, r)
+		return
+	}
+
+	n := h.countRequest()
+	if h.crashOnRequest > 0 && n == h.crashOnRequest {
+		// Deliberately exit without any cleanup to simulate a hard crash.
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Crashing on request %d", n))
+		os.Exit(1)
+	}
+
+	if h.rateLimiter != nil {
+		if ok, retryAfter := h.rateLimiter.take(); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	if h.requestQuota > 0 && !h.takeQuota() {
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	if h.continueDelay > 0 && strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		// net/http sends 100 Continue on the first read of the body, which nothing has read yet
+		select {
+		case <-time.After(h.continueDelay):
+		case <-r.Context().Done():
+			return
+		}
+		// read the body now to send it since nothing may read it later, e.g. with logSummary.
+		// readBody reads it below with requestReadTimeout.
+		if h.requestReadTimeout == 0 {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+	}
+
+	if h.requestReadTimeout > 0 {
+		if err := h.readBody(w, r); err != nil {
+			if os.IsTimeout(err) {
+				http.Error(w, http.StatusText(http.StatusRequestTimeout), http.StatusRequestTimeout)
+			} else {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			}
+			return
+		}
+	}
+
+	var resp *response
+	if always := h.alwaysFor(r.URL.Path); always != nil {
+		resp = always
+	} else if example := h.openAPIResponse(r); example != nil {
+		resp = example
+	} else if result, ok := h.asyncResultFor(r); ok {
+		if result == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		resp = result.resp
+		if result.isLast {
+			go h.shutdownServer()
+		}
+	} else if code, ok := h.statusFromRequest(r); ok {
+		if code == 0 {
+			http.Error(w, fmt.Sprintf("invalid status in path: %q", r.URL.Path), http.StatusBadRequest)
+			return
+		}
+		resp = h.statusResponse(code)
+	} else if index := r.Header.Get(h.indexHeader); h.indexHeader != "" && index != "" {
+		resp = h.responseAt(index)
+		if resp == nil {
+			http.Error(w, fmt.Sprintf("invalid response index: %q", index), http.StatusBadRequest)
+			return
+		}
+	} else if h.countHeader != "" && len(h.responses) > 0 {
+		counter := r.Header.Get(h.countHeader)
+		var isLast bool
+		var expected int
+		resp, isLast, expected = h.countedResponse(counter)
+		if resp == nil && expected == 0 {
+			// the sequence is finished as without the counter
+			if h.notFoundTemplate != nil {
+				h.serveNotFound(w, r)
+				return
+			}
+			panic(http.ErrAbortHandler)
+		}
+		if resp == nil {
+			http.Error(w, fmt.Sprintf("expected %s: %d, got: %q", h.countHeader, expected, counter), http.StatusConflict)
+			return
+		}
+		if isLast {
+			go h.shutdownServer()
+		}
+	} else if h.hashSelect != "" && len(h.responses) > 0 {
+		resp = h.hashResponse(r)
+	} else {
+		if status := h.nextRequiresBody(r); status != 0 && !hasBody(r) {
+			http.Error(w, "request body is required", status)
+			return
+		}
+
+		var isLast bool
+		resp, isLast = h.getResponse(r)
+		if resp == nil {
+			if r.TLS == nil && h.leftForTLS() {
+				serveUpgradeRequired(w)
+				return
+			}
+			if h.notFoundTemplate != nil {
+				h.serveNotFound(w, r)
+				return
+			}
+			panic(http.ErrAbortHandler)
+		}
+
+		if h.preferAsync && prefersAsync(r) {
+			h.logRequest(r)
+			serveAccepted(w, h.holdAsync(resp, isLast))
+			return
+		}
+
+		if isLast {
+			go h.shutdownServer()
+		}
+	}
+
+	if resp.requireTLS && r.TLS == nil {
+		serveUpgradeRequired(w)
+		return
+	}
+	if resp.requireBody != 0 && !hasBody(r) {
+		http.Error(w, "request body is required", resp.requireBody)
+		return
+	}
+
+	h.logRequest(r)
+	if h.logCurl {
+		h.logger.log(h.logger.stdout(), curlCommand(r))
+	}
+
+	method := h.effectiveMethod(r)
+	if method != r.Method {
+		h.logger.log(h.logger.stdout(), fmt.Sprintf("Method overridden: %s -> %s", r.Method, method))
+	}
+
+	delay := resp.delay + h.headerDelay(r) + h.jitterFor(r)
+	if h.delayRamp > 0 {
+		delay += time.Duration(h.countServed()-1) * h.delayRamp
+	}
+	chaos := h.chaosFor(resp)
+	if chaos != chaosNormal {
+		h.logger.log(h.logger.stdout(), fmt.Sprintf("Chaos: %s", chaos))
+	}
+	switch chaos {
+	case chaosDelay:
+		delay += resp.chaosDelay
+	case chaosDrop:
 		panic(http.ErrAbortHandler)
 	}
 
-	if isLast {
-		go h.shutdownServer()
+	delayStart := time.Now()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	delayed := time.Since(delayStart)
+
+	data := newRequestData(r)
+	data.Index = n
+	data.Remaining = h.remaining()
+	body, err := resp.bodyFor(method, data)
+	if re := (*reloadError)(nil); errors.As(err, &re) {
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Failed to reload the body file on request %d: %v", n, re))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Failed to render body template on request %d: %v", n, err))
+		errorBody := h.templateErrorBody
+		if errorBody == "" {
+			errorBody = http.StatusText(http.StatusInternalServerError)
+		}
+		http.Error(w, errorBody, http.StatusInternalServerError)
+		return
+	}
+
+	if resp.gzipMinSize > 0 && len(body) >= resp.gzipMinSize {
+		body = gzipBody(body, resp.gzipLevel)
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+
+	if h.echoClientCert {
+		echoClientCert(w.Header(), r)
+	}
+	if h.reflectHeaders != "" {
+		reflectHeaders(w.Header(), r, h.reflectHeaders)
+	}
+
+	if chaos == chaosTruncate {
+		h.writeTruncated(w, resp, body)
+		return
+	}
+	if resp.obsFold != nil {
+		h.writeObsFold(w, r, resp, body)
+		return
+	}
+	writeStart := time.Now()
+	h.writeResponse(w, r, resp, body)
+	if h.timingLog {
+		h.logger.log(h.logger.stdout(), fmt.Sprintf("Timing: delay=%s write=%s total=%s", delayed, time.Since(writeStart), time.Since(start)))
+	}
+}
+
+// echoClientCert sets the subject, the issuer and the serial number of
+// the verified client certificate of the request to the headers if any.
+func echoClientCert(h http.Header, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	h.Set("X-Client-Cert-Subject", cert.Subject.String())
+	h.Set("X-Client-Cert-Issuer", cert.Issuer.String())
+	h.Set("X-Client-Cert-Serial", cert.SerialNumber.String())
+}
+
+// reflectHeaders adds each request header to h with the name prefixed by prefix.
+func reflectHeaders(h http.Header, r *http.Request, prefix string) {
+	for name, values := range r.Header {
+		for _, v := range values {
+			h.Add(prefix+name, v)
+		}
+	}
+}
+
+// headerDelay returns the delay requested by the delayFromHeader header of the request.
+// Invalid values are logged and ignored.
+func (h *handler) headerDelay(r *http.Request) time.Duration {
+	if h.delayFromHeader == "" {
+		return 0
+	}
+	v := r.Header.Get(h.delayFromHeader)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Ignoring invalid delay in %s: %q", h.delayFromHeader, v))
+		return 0
+	}
+	return d
+}
+
+// statusFromRequest returns the status code in the path segment after statusFromPath and true
+// if the request is to such a path. The status code is zero if the segment is not a valid status.
+func (h *handler) statusFromRequest(r *http.Request) (int, bool) {
+	if h.statusFromPath == "" {
+		return 0, false
+	}
+	segment, ok := strings.CutPrefix(r.URL.Path, strings.TrimSuffix(h.statusFromPath, "/")+"/")
+	if !ok || strings.Contains(segment, "/") {
+		return 0, false
+	}
+	code, err := strconv.Atoi(segment)
+	if err != nil || code < 200 || code > 599 {
+		return 0, true
+	}
+	return code, true
+}
+
+// newStatusResponse returns the response of the status with its status text as the body,
+// with the global headers and body prefix and suffix of c.
+func newStatusResponse(code int, c *serverConfig) *response {
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		return &response{statusCode: code, headers: c.headers.Clone()}
+	}
+	resp := newResponse(&responseConfig{statusCode: code, body: []byte(http.StatusText(code) + "\n")}, c)
+	if resp.headers.Get("Content-Type") == "" {
+		resp.headers.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	return resp
+}
+
+// bodyFor returns the body of the response to the request served as method.
+func (r *response) bodyFor(method string, data *requestData) ([]byte, error) {
+	if b, ok := r.methodBodies[method]; ok {
+		return b, nil
+	}
+	if r.render != nil {
+		return r.render(data)
+	}
+	return r.body, nil
+}
+
+// remaining returns the number of responses left in the sequence.
+func (h *handler) remaining() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.responses) - h.pos - len(h.servedAhead)
+}
+
+// methodOverrideHeader is the header overriding the method of requests if honored.
+const methodOverrideHeader = "X-HTTP-Method-Override"
+
+// effectiveMethod returns the method the request is served as.
+func (h *handler) effectiveMethod(r *http.Request) string {
+	if h.honorMethodOverride {
+		if method := r.Header.Get(methodOverrideHeader); method != "" {
+			return strings.ToUpper(method)
+		}
+	}
+	return r.Method
+}
+
+// readBody reads the request body within requestReadTimeout
+// and replaces the body with what was read.
+func (h *handler) readBody(w http.ResponseWriter, r *http.Request) error {
+	rc := http.NewResponseController(w)
+	// the deadline is not supported by some writers like httptest.ResponseRecorder,
+	// where the body is read without the deadline.
+	rc.SetReadDeadline(time.Now().Add(h.requestReadTimeout))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	rc.SetReadDeadline(time.Time{})
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// logRequest logs the request, dumping it entirely unless logSummary is set.
+func (h *handler) logRequest(r *http.Request) {
+	if h.logSummary {
+		h.logger.log(h.logger.stdout(), summarizeRequest(r))
+		return
 	}
 
 	reqBytes, err := httputil.DumpRequest(r, true)
 	if err != nil {
-		h.logger.log(os.Stderr, fmt.Sprintf("Failed to dump request: %v", err))
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Failed to dump request: %v", err))
 	} else {
-		h.logger.log(os.Stdout, string(reqBytes))
+		h.logger.log(h.logger.stdout(), string(reqBytes))
+	}
+}
+
+// curlCommand returns a curl command line reproducing the method, the URL and the headers of the request.
+func curlCommand(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "curl -X %s %s", r.Method, shellQuote(scheme+"://"+r.Host+r.URL.RequestURI()))
+
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range r.Header[name] {
+			fmt.Fprintf(&b, " -H %s", shellQuote(name+": "+v))
+		}
+	}
+	return b.String()
+}
+
+// shellQuote quotes the string for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// summaryHeaders are the headers included in the request summary.
+var summaryHeaders = []string{"Host", "User-Agent", "Content-Type"}
+
+// summarizeRequest returns a line with the method, the path and the key headers of the request.
+func summarizeRequest(r *http.Request) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", r.Method, r.URL.RequestURI())
+	for _, name := range summaryHeaders {
+		value := r.Header.Get(name)
+		if name == "Host" {
+			value = r.Host
+		}
+		if value != "" {
+			fmt.Fprintf(&b, " %s=%q", name, value)
+		}
 	}
+	return b.String()
+}
 
+// warnIgnoredBodies logs the responses whose bodies are not sent because of their statuses.
+func (h *handler) warnIgnoredBodies() {
+	for i, resp := range h.responses {
+		if resp.bodyIgnored {
+			h.logger.log(h.logger.stderr(), fmt.Sprintf("Ignoring the body of status %d of response %d", resp.statusCode, i+1))
+		}
+	}
+	paths := make([]string, 0, len(h.always))
+	for path := range h.always {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		for _, resp := range h.always[path] {
+			if resp.bodyIgnored {
+				h.logger.log(h.logger.stderr(), fmt.Sprintf("Ignoring the body of status %d for %s", resp.statusCode, path))
+			}
+		}
+	}
+}
+
+// setHeaders sets the headers of the response with the body to w,
+// shared by every way the response is written.
+func (h *handler) setHeaders(w http.ResponseWriter, resp *response, body []byte) {
 	copyHeader(w.Header(), resp.headers)
+	if h.mergeHeaders {
+		mergeHeader(w.Header())
+	}
+	noBody := resp.statusCode == http.StatusNoContent || resp.statusCode == http.StatusNotModified
+	if resp.badDigest != "" && !noBody {
+		setBadDigest(w.Header(), resp.badDigest, body)
+	}
+}
+
+// writeResponse writes the response with the body to the request.
+func (h *handler) writeResponse(w http.ResponseWriter, r *http.Request, resp *response, body []byte) {
+	noBody := resp.statusCode == http.StatusNoContent || resp.statusCode == http.StatusNotModified
+	if noBody {
+		// newResponse drops the body, which is warned by warnIgnoredBodies
+		body = nil
+	}
+
+	h.setHeaders(w, resp, body)
+	switch {
+	case noBody:
+		// neither a body nor its length is sent for these statuses
+	case resp.ndjson, resp.sse:
+		// the body is streamed without its length
+	case resp.corruptLength > 0:
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)+resp.corruptLength))
+	case resp.trailerChecksum != "":
+		// the body is chunked without its length so that the trailer can follow it
+		w.Header().Set("Trailer", contentDigestTrailer)
+	case w.Header().Get("Content-Length") == "":
+		// body is already compressed by newResponse if requested,
+		// so this is the length on the wire
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
 
 	w.WriteHeader(resp.statusCode)
-	w.Write(resp.body)
+	if noBody {
+		return
+	}
+	if resp.ndjson {
+		h.streamNDJSON(w, r, body, resp.ndjsonInterval)
+		return
+	}
+	if resp.sse {
+		h.streamSSE(w, r, body, resp.sseInterval, resp.sseKeepalive)
+		return
+	}
+	if _, err := w.Write(body); err != nil {
+		h.logWriteError(err)
+		return
+	}
+	if resp.trailerChecksum != "" {
+		w.Header().Set(contentDigestTrailer, contentDigest(resp.trailerChecksum, body))
+	}
+
+	if resp.alsoSend > 0 {
+		h.sendExtraResponses(w, resp, body)
+		return
+	}
+
+	if resp.corruptLength > 0 {
+		// Keep the connection open so that the client waits for the rest of the body
+		// until it gives up.
+		http.NewResponseController(w).Flush()
+		<-r.Context().Done()
+	}
+}
+
+// serveUpgradeRequired responds 426 to plaintext requests for responses requiring TLS.
+func serveUpgradeRequired(w http.ResponseWriter) {
+	w.Header().Set("Upgrade", "TLS/1.2, HTTP/1.1")
+	w.Header().Set("Connection", "Upgrade")
+	http.Error(w, http.StatusText(http.StatusUpgradeRequired), http.StatusUpgradeRequired)
+}
+
+// sendExtraResponses writes alsoSend copies of the response as raw HTTP/1.1 responses
+// after the response already written, and closes the connection.
+func (h *handler) sendExtraResponses(w http.ResponseWriter, resp *response, body []byte) {
+	rc := http.NewResponseController(w)
+	// the response must be written out before the connection is taken over
+	if err := rc.Flush(); err != nil {
+		h.logWriteError(err)
+		return
+	}
+	conn, bufrw, err := rc.Hijack()
+	if err != nil {
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Failed to send extra responses: %v", err))
+		return
+	}
+	defer conn.Close()
+
+	for i := 0; i < resp.alsoSend; i++ {
+		extra := &http.Response{
+			StatusCode:    resp.statusCode,
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        resp.headers.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+		}
+		if err := extra.Write(bufrw); err != nil {
+			h.logWriteError(err)
+			return
+		}
+	}
+	if err := bufrw.Flush(); err != nil {
+		h.logWriteError(err)
+	}
+}
+
+// logWriteError logs the error writing the response body.
+func (h *handler) logWriteError(err error) {
+	if isDisconnected(err) {
+		h.logger.log(h.logger.stderr(), "Client disconnected while writing the response")
+	} else {
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Failed to write the response: %v", err))
+	}
+}
+
+// traceExcludedHeaders are the headers not echoed to TRACE requests since they may contain credentials.
+var traceExcludedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// serveTrace echoes the request as the message/http body.
+func (h *handler) serveTrace(w http.ResponseWriter, r *http.Request) {
+	echo := r.Clone(r.Context())
+	for _, name := range traceExcludedHeaders {
+		echo.Header.Del(name)
+	}
+	body, err := httputil.DumpRequest(echo, false)
+	if err != nil {
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Failed to dump request: %v", err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "message/http")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// serveNotFound responds 404 with the body rendered from notFoundTemplate.
+func (h *handler) serveNotFound(w http.ResponseWriter, r *http.Request) {
+	body, err := renderTemplate(h.notFoundTemplate, newRequestData(r))
+	if err != nil {
+		h.logger.log(h.logger.stderr(), fmt.Sprintf("Failed to render not found template: %v", err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(body)
 }
 
 func newServer(c *serverConfig) *server {
-	ch := make(chan error)
-	s := &http.Server{
-		Addr: c.addr,
+	s := &server{
+		Server: &http.Server{
+			Addr: c.addr,
+		},
+		shutdownCh:      make(chan error),
+		shutdownWebhook: c.shutdownWebhook,
+		startupDelay:    c.startupDelay,
+		maxRuntime:      c.maxRuntime,
 	}
 
-	handler := newHandler(c.headers, c.responses, func() { ch <- s.Shutdown(context.Background()) })
+	s.handler = newHandler(c, func() { s.shutdown("sequence complete") })
+
+	if c.tls != nil && (c.tls.alpn != nil || c.tls.clientCAs != nil) {
+		s.TLSConfig = &tls.Config{NextProtos: c.tls.alpn}
+		if c.tls.alpn != nil && !slices.Contains(c.tls.alpn, "h2") {
+			// a non-nil map disables HTTP/2, which would add h2 to NextProtos
+			s.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+		if c.tls.clientCAs != nil {
+			s.TLSConfig.ClientCAs = c.tls.clientCAs
+			s.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
 
-	s.Handler = handler
+	s.Handler = s.handler
+	if c.maxRequestsPerConn > 0 {
+		s.ConnContext = withConnRequests
+	}
 
-	return &server{s, ch}
+	return s
 }
 
-func newHandler(grobalHeader http.Header, respConfigs []*responseConfig, shutdownFunc func()) *handler {
+func newHandler(c *serverConfig, shutdownFunc func()) *handler {
+	if c.noShutdown {
+		// the server runs until stopped by a signal
+		shutdownFunc = func() {}
+	}
 	handler := &handler{
-		shutdownServer: shutdownFunc,
+		shutdownServer:      shutdownFunc,
+		indexHeader:         c.indexHeader,
+		crashOnRequest:      c.crashOnRequest,
+		delayRamp:           c.delayRamp,
+		jitter:              c.jitter,
+		jitterByPath:        c.jitterByPath,
+		mergeHeaders:        c.mergeHeaders,
+		notFoundTemplate:    c.notFoundTemplate,
+		logSummary:          c.logSummary,
+		controlPath:         c.controlPath,
+		requestReadTimeout:  c.requestReadTimeout,
+		honorMethodOverride: c.honorMethodOverride,
+		echoClientCert:      c.echoClientCert,
+		reflectHeaders:      c.reflectHeaders,
+		templateErrorBody:   c.templateErrorBody,
+		ignoreFavicon:       c.ignoreFavicon,
+		noShutdown:          c.noShutdown,
+		loop:                c.loop,
+		idleShutdown:        c.idleShutdown,
+		continueDelay:       c.continueDelay,
+		maxRequestsPerConn:  c.maxRequestsPerConn,
+		timingLog:           c.timingLog,
+		connectStatus:       c.connectStatus,
+		connectTunnel:       c.connectTunnel,
+		hashSelect:          c.hashSelect,
+		countHeader:         c.countHeader,
+		adminPath:           c.adminPath,
+		requestQuota:        c.requestQuota,
+		delayFromHeader:     c.delayFromHeader,
+		logCurl:             c.logCurl,
+		enableTrace:         c.enableTrace,
+		statusFromPath:      c.statusFromPath,
+		preferAsync:         c.preferAsync,
+	}
+	handler.logger.timeFormat = c.timeFormat
+	if c.statusFromPath != "" {
+		handler.statusResponse = func(code int) *response { return newStatusResponse(code, c) }
+	}
+	if c.jitter > 0 {
+		if c.jitterByPath {
+			if c.seed != nil {
+				handler.jitterSeed = *c.seed
+			}
+		} else {
+			handler.jitterRand = newRand(c.seed)
+		}
+	}
+	if c.rateLimit != nil {
+		handler.rateLimiter = newTokenBucket(c.rateLimit)
 	}
 
-	handler.responses = make([]*response, len(respConfigs))
-	for i, rc := range respConfigs {
-		r := newResponse(rc, grobalHeader)
-		handler.responses[i] = r
+	handler.responses = []*response{}
+	started := time.Now()
+	for _, rc := range c.responses {
+		r := newResponse(rc, c)
+		r.activeFrom = rc.activeFrom.resolve(started)
+		r.activeUntil = rc.activeUntil.resolve(started)
+		if rc.chaos != nil && handler.chaosRand == nil {
+			handler.chaosRand = newRand(c.seed)
+		}
+		if rc.alwaysPath != "" {
+			if handler.always == nil {
+				handler.always = map[string][]*response{}
+			}
+			if c.roundRobinPath {
+				handler.always[rc.alwaysPath] = append(handler.always[rc.alwaysPath], r)
+			} else {
+				// the last one for the path wins
+				handler.always[rc.alwaysPath] = []*response{r}
+			}
+			continue
+		}
+		if i := len(handler.responses); i < len(c.responseDelays) {
+			r.delay = c.responseDelays[i]
+		}
+		handler.responses = append(handler.responses, r)
+	}
+
+	for _, route := range c.openAPI {
+		o := &openAPIOperation{segments: strings.Split(route.path, "/"), method: route.method}
+		for _, rc := range route.responses {
+			o.responses = append(o.responses, newResponse(rc, c))
+		}
+		handler.openAPI = append(handler.openAPI, o)
+	}
+
+	switch c.order {
+	case "reverse":
+		for i, j := 0, len(handler.responses)-1; i < j; i, j = i+1, j-1 {
+			handler.responses[i], handler.responses[j] = handler.responses[j], handler.responses[i]
+		}
+	case "shuffle":
+		rnd := newRand(c.seed)
+		rnd.Shuffle(len(handler.responses), func(i, j int) {
+			handler.responses[i], handler.responses[j] = handler.responses[j], handler.responses[i]
+		})
 	}
 
 	return handler
@@ -138,14 +1572,144 @@ func copyHeader(dst, src http.Header) {
 	}
 }
 
-func newResponse(c *responseConfig, baseHeader http.Header) *response {
+// mergeHeader joins multiple values of each header with ", ".
+// Set-Cookie is left as is since its values cannot be combined.
+func mergeHeader(h http.Header) {
+	for k, vs := range h {
+		if len(vs) > 1 && k != "Set-Cookie" {
+			h[k] = []string{strings.Join(vs, ", ")}
+		}
+	}
+}
+
+// convertLineEnding converts the line endings of the body to "crlf" or "lf".
+func convertLineEnding(body []byte, lineEnding string) []byte {
+	switch lineEnding {
+	case "crlf":
+		body = bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n"))
+		return bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n"))
+	case "lf":
+		return bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n"))
+	}
+	return body
+}
+
+func newResponse(rc *responseConfig, c *serverConfig) *response {
+	// neither a body nor anything encoding it is sent for these statuses
+	noBody := rc.statusCode == http.StatusNoContent || rc.statusCode == http.StatusNotModified
+	// with the minimum size, whether to compress is decided per body when it is served
+	gzipNow := rc.gzip && c.gzipMinSize == 0 && !noBody
+	wrap := func(b []byte) []byte {
+		if noBody {
+			return nil
+		}
+		body := make([]byte, 0, len(c.bodyPrefix)+len(b)+len(c.bodySuffix))
+		body = append(body, c.bodyPrefix...)
+		body = append(body, b...)
+		body = append(body, c.bodySuffix...)
+		body = convertLineEnding(body, rc.lineEnding)
+		if rc.grpcWeb {
+			body = grpcWebFrame(body, rc.grpcWebText)
+		}
+		if gzipNow {
+			body = gzipBody(body, c.gzipLevel)
+		}
+		if rc.brotli {
+			body = brotliBody(body)
+		}
+		return body
+	}
+
+	body, multipartType := rc.body, ""
+	if rc.multipartParts != nil {
+		body, multipartType = multipartBody(rc.multipartParts)
+	}
+
 	r := &response{
-		statusCode: c.statusCode,
-		body:       c.body,
-		headers:    baseHeader.Clone(),
+		statusCode:      rc.statusCode,
+		body:            wrap(body),
+		headers:         c.headers.Clone(),
+		corruptLength:   rc.corruptLength,
+		untilSignal:     rc.untilSignal,
+		matchCookie:     rc.matchCookie,
+		requireTLS:      rc.requireTLS,
+		requireBody:     rc.requireBody,
+		trailerChecksum: rc.trailerChecksum,
+		badDigest:       rc.badDigest,
+		chaos:           rc.chaos,
+		chaosDelay:      rc.chaosDelay,
+		ndjson:          rc.ndjson,
+		ndjsonInterval:  rc.ndjsonInterval,
+		sse:             rc.sse,
+		sseInterval:     rc.sseInterval,
+		sseKeepalive:    rc.sseKeepalive,
+		matchLanguage:   rc.matchLanguage,
+		method:          rc.method,
+		alsoSend:        rc.alsoSend,
+		obsFold:         rc.obsFold,
+	}
+
+	if rc.bodyTemplate != nil {
+		r.render = func(data *requestData) ([]byte, error) {
+			b, err := renderTemplate(rc.bodyTemplate, data)
+			if err != nil {
+				return nil, err
+			}
+			return wrap(b), nil
+		}
+	}
+
+	if rc.reloadFile != "" {
+		r.render = func(*requestData) ([]byte, error) {
+			b, err := reloadBody(rc.reloadFile, rc.reloadLimit)
+			if err != nil {
+				return nil, err
+			}
+			return wrap(b), nil
+		}
+	}
+
+	if rc.methodBodies != nil {
+		r.methodBodies = make(map[string][]byte, len(rc.methodBodies))
+		for method, b := range rc.methodBodies {
+			r.methodBodies[method] = wrap(b)
+		}
+	}
+
+	if rc.grpcWeb {
+		r.headers.Set("Content-Type", grpcWebContentType(rc.grpcWebText))
+	}
+	if rc.ndjson {
+		r.headers.Set("Content-Type", ndjsonContentType)
+	}
+	if rc.sse {
+		r.headers.Set("Content-Type", sseContentType)
+		r.headers.Set("Cache-Control", "no-cache")
+	}
+	if multipartType != "" {
+		r.headers.Set("Content-Type", multipartType)
+	}
+	if rc.gzipBomb > 0 && !noBody {
+		// the body prefix and suffix would corrupt the stream
+		r.body = rc.body
+		r.headers.Set("Content-Encoding", "gzip")
+	}
+	if gzipNow {
+		r.headers.Set("Content-Encoding", "gzip")
+	} else if rc.gzip && !noBody {
+		r.gzipMinSize = c.gzipMinSize
+		r.gzipLevel = c.gzipLevel
+	}
+	if rc.brotli && !noBody {
+		r.headers.Set("Content-Encoding", "br")
+	}
+	if noBody {
+		r.bodyIgnored = len(rc.body) > 0 || len(rc.methodBodies) > 0 || rc.bodyTemplate != nil ||
+			rc.multipartParts != nil || rc.reloadFile != "" || rc.gzipBomb > 0
+		r.render = nil
 	}
 
-	copyHeader(r.headers, c.headers)
+	copyHeader(r.headers, rc.headers)
 
 	return r
 }
@@ -1,14 +1,32 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"text/template"
 	"time"
 )
 
@@ -49,6 +67,65 @@ func httpHeader(m map[string][]string) http.Header {
 	return header
 }
 
+func TestLoggerTimeFormat(t *testing.T) {
+	cases := []struct {
+		name       string
+		timeFormat string
+		parse      func(s string) error
+	}{
+		{
+			name:       "RFC3339",
+			timeFormat: "rfc3339",
+			parse: func(s string) error {
+				_, err := time.Parse(time.RFC3339, s)
+				return err
+			},
+		},
+		{
+			name:       "Unix",
+			timeFormat: "unix",
+			parse: func(s string) error {
+				_, err := strconv.ParseInt(s, 10, 64)
+				return err
+			},
+		},
+		{
+			name:       "Layout",
+			timeFormat: "2006/01/02-15:04:05",
+			parse: func(s string) error {
+				_, err := time.Parse("2006/01/02-15:04:05", s)
+				return err
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			l := &logger{timeFormat: c.timeFormat}
+			buf := &bytes.Buffer{}
+			l.log(buf, "message")
+
+			timestamp, msg, ok := strings.Cut(strings.TrimSuffix(buf.String(), "\n"), " ")
+			if !ok || msg != "message" {
+				t.Fatalf("log is not prefixed with a timestamp: %q", buf.String())
+			}
+			if err := c.parse(timestamp); err != nil {
+				t.Errorf("timestamp %q does not match the format %q: %s", timestamp, c.timeFormat, err)
+			}
+		})
+	}
+
+	l := &logger{}
+	buf := &bytes.Buffer{}
+	l.log(buf, "message")
+	if buf.String() != "message\n" {
+		t.Errorf("log without time format should not be prefixed: %q", buf.String())
+	}
+}
+
 func TestNewServerSuccess(t *testing.T) {
 	arg := &serverConfig{
 		addr: ":1234",
@@ -144,6 +221,56 @@ func TestNewServerSuccess(t *testing.T) {
 	}
 }
 
+func TestNewHandlerOrder(t *testing.T) {
+	newConfig := func(order string, seed *int64) *serverConfig {
+		c := &serverConfig{
+			headers: http.Header{},
+			order:   order,
+			seed:    seed,
+		}
+		for i := 0; i < 10; i++ {
+			c.responses = append(c.responses, &responseConfig{statusCode: 200, body: []byte(strconv.Itoa(i))})
+		}
+		return c
+	}
+	servedOrder := func(h *handler) []string {
+		order := []string{}
+		for range h.responses {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			order = append(order, w.Body.String())
+		}
+		return order
+	}
+
+	sequential := servedOrder(newHandler(newConfig("", nil), func() {}))
+	expectSequential := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	if !reflect.DeepEqual(sequential, expectSequential) {
+		t.Errorf("sequential order: expect %v, but got %v", expectSequential, sequential)
+	}
+
+	reverse := servedOrder(newHandler(newConfig("reverse", nil), func() {}))
+	expectReverse := []string{"9", "8", "7", "6", "5", "4", "3", "2", "1", "0"}
+	if !reflect.DeepEqual(reverse, expectReverse) {
+		t.Errorf("reverse order: expect %v, but got %v", expectReverse, reverse)
+	}
+
+	seed := int64(1)
+	shuffled1 := servedOrder(newHandler(newConfig("shuffle", &seed), func() {}))
+	shuffled2 := servedOrder(newHandler(newConfig("shuffle", &seed), func() {}))
+	if !reflect.DeepEqual(shuffled1, shuffled2) {
+		t.Errorf("shuffled orders with the same seed differ: %v and %v", shuffled1, shuffled2)
+	}
+	if reflect.DeepEqual(shuffled1, expectSequential) {
+		t.Errorf("responses are not shuffled: %v", shuffled1)
+	}
+	sorted := append([]string{}, shuffled1...)
+	sort.Strings(sorted)
+	if !reflect.DeepEqual(sorted, expectSequential) {
+		t.Errorf("shuffled order is not a permutation of responses: %v", shuffled1)
+	}
+}
+
 func TestHandler_ServeHTTP(t *testing.T) {
 	shutdownCh := make(chan struct{})
 	handler := &handler{
@@ -226,6 +353,2747 @@ func TestHandler_ServeHTTP(t *testing.T) {
 	}
 }
 
+func TestHandler_ServeHTTPNoShutdown(t *testing.T) {
+	c, err := parseArgs([]string{"--no-shutdown", "201", "created", "200", "ok"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %s", err)
+	}
+	handler := newHandler(c, func() {
+		t.Error("shutdownServer should not be called")
+	})
+	handler.logger.out = io.Discard
+
+	expectCodes := []int{201, 200, 200, 200, 200}
+	for i, expect := range expectCodes {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != expect {
+			t.Errorf("%d-th request: code does not match: expect %d, got: %d", i, expect, w.Code)
+		}
+	}
+}
+
+func TestHandler_ServeHTTPNoShutdownUnmatched(t *testing.T) {
+	c, err := parseArgs([]string{"--no-shutdown", "200", "a", "201", "b", "--method", "POST"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %s", err)
+	}
+	handler := newHandler(c, func() {
+		t.Error("shutdownServer should not be called")
+	})
+	handler.logger.out = io.Discard
+
+	serve := func(method string) (w *httptest.ResponseRecorder, aborted bool) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err != http.ErrAbortHandler {
+					panic(err)
+				}
+				aborted = true
+			}
+		}()
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "/", nil))
+		return w, false
+	}
+
+	for i, method := range []string{"GET", "POST", "POST"} {
+		if w, aborted := serve(method); aborted {
+			t.Errorf("%d-th request (%s) should not be aborted", i, method)
+		} else if i > 0 && w.Code != 201 {
+			t.Errorf("%d-th request (%s): expect 201, got: %d", i, method, w.Code)
+		}
+	}
+	if w, aborted := serve("GET"); !aborted {
+		t.Errorf("GET after the sequence should be aborted since the last response is for POST, but got: %d", w.Code)
+	}
+}
+
+func TestHandler_ServeHTTPLoop(t *testing.T) {
+	newLoopHandler := func(t *testing.T) *handler {
+		c, err := parseArgs([]string{"--loop", "200", "first", "200", "second", "200", "third"})
+		if err != nil {
+			t.Fatalf("parseArgs failed: %s", err)
+		}
+		handler := newHandler(c, func() {
+			t.Error("shutdownServer should not be called")
+		})
+		handler.logger.out = io.Discard
+		return handler
+	}
+	serve := func(handler *handler) string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Body.String()
+	}
+
+	t.Run("Sequential", func(t *testing.T) {
+		t.Parallel()
+
+		handler := newLoopHandler(t)
+		expect := []string{"first", "second", "third", "first", "second", "third", "first"}
+		for i, e := range expect {
+			if body := serve(handler); body != e {
+				t.Errorf("%d-th request: expect %q, got: %q", i, e, body)
+			}
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		t.Parallel()
+
+		handler := newLoopHandler(t)
+		const cycles = 20
+		bodies := make(chan string, 3*cycles)
+		var wg sync.WaitGroup
+		for i := 0; i < 3*cycles; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				bodies <- serve(handler)
+			}()
+		}
+		wg.Wait()
+		close(bodies)
+
+		counts := map[string]int{}
+		for body := range bodies {
+			counts[body]++
+		}
+		for _, body := range []string{"first", "second", "third"} {
+			if counts[body] != cycles {
+				t.Errorf("%q is expected to be served %d times, but %d times: %v", body, cycles, counts[body], counts)
+			}
+		}
+		if handler.pos != 0 {
+			t.Errorf("pos is expected to wrap back to 0 after whole cycles, but %d", handler.pos)
+		}
+	})
+
+	t.Run("Method", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := parseArgs([]string{"--loop", "200", "a", "--method", "GET", "201", "b", "--method", "POST"})
+		if err != nil {
+			t.Fatalf("parseArgs failed: %s", err)
+		}
+		handler := newHandler(c, func() {
+			t.Error("shutdownServer should not be called")
+		})
+		handler.logger.out = io.Discard
+
+		for i, s := range []struct {
+			method string
+			body   string
+		}{
+			{method: "GET", body: "a"},
+			{method: "GET", body: "a"},
+			{method: "POST", body: "b"},
+			{method: "POST", body: "b"},
+			{method: "GET", body: "a"},
+		} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(s.method, "/", nil))
+			if w.Body.String() != s.body {
+				t.Errorf("%d-th request (%s): expect %q, got: %q", i, s.method, s.body, w.Body.String())
+			}
+		}
+	})
+
+	t.Run("CountHeader", func(t *testing.T) {
+		t.Parallel()
+
+		shutdownCh := make(chan struct{}, 1)
+		handler := &handler{
+			responses: []*response{
+				{statusCode: 200, body: []byte("first")},
+				{statusCode: 201, body: []byte("second")},
+			},
+			shutdownServer: func() { shutdownCh <- struct{}{} },
+			countHeader:    "X-Count",
+			loop:           true,
+		}
+		handler.logger.out = io.Discard
+
+		expect := []string{"first", "second", "first", "second"}
+		for i, e := range expect {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Count", strconv.Itoa(i+1))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Body.String() != e {
+				t.Errorf("%d-th request: expect %q, got: %q", i, e, w.Body.String())
+			}
+		}
+
+		select {
+		case <-shutdownCh:
+			t.Error("shutdownServer should not be called in a loop")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
+
+func TestHandler_ServeHTTPMethod(t *testing.T) {
+	t.Parallel()
+
+	c, err := parseArgs([]string{
+		"201", "created", "--method", "post",
+		"200", "fetched", "--method", "GET",
+		"200", "any",
+	})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %s", err)
+	}
+	handler := newHandler(c, func() {})
+	handler.logger.out = io.Discard
+
+	steps := []struct {
+		method string
+		status int
+		body   string
+	}{
+		{method: "GET", status: 200, body: "fetched"},
+		{method: "POST", status: 201, body: "created"},
+		{method: "PUT", status: 200, body: "any"},
+	}
+	for i, s := range steps {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(s.method, "/", nil))
+		if w.Code != s.status || w.Body.String() != s.body {
+			t.Errorf("%d-th request (%s): expect %d %q, got: %d %q", i, s.method, s.status, s.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandler_ServeHTTPMethodEffective(t *testing.T) {
+	c, err := parseArgs([]string{
+		"--honor-method-override",
+		"200", "fetched", "--method", "GET",
+		"204", "", "--method", "DELETE",
+		"200", "any",
+	})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %s", err)
+	}
+	handler := newHandler(c, func() {})
+	handler.logger.out = io.Discard
+
+	// HEAD is served the response to GET
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("HEAD", "/", nil))
+	if w.Code != 200 {
+		t.Errorf("HEAD: expect 200, got: %d", w.Code)
+	}
+	handler.mu.Lock()
+	if handler.pos != 1 {
+		t.Errorf("HEAD is expected to be served the response to GET, but next is %d", handler.pos)
+	}
+	handler.mu.Unlock()
+
+	// the overriding method is matched instead of POST
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("X-HTTP-Method-Override", "delete")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != 204 {
+		t.Errorf("POST overridden as DELETE: expect 204, got: %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHandler_ServeHTTPUntilSignal(t *testing.T) {
+	c, err := parseArgs([]string{"503", "busy", "--repeat", "3", "200", "ok", "--until-signal"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %s", err)
+	}
+	handler := newHandler(c, func() {
+		t.Error("shutdownServer should not be called")
+	})
+
+	expectCodes := []int{503, 503, 503, 200, 200, 200, 200, 200}
+	for i, expect := range expectCodes {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != expect {
+			t.Errorf("%d-th request: code does not match: expect %d, got: %d", i, expect, w.Code)
+		}
+	}
+}
+
+func TestHandler_ServeHTTPIndexHeader(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{
+				statusCode: 200,
+				body:       []byte("first"),
+			},
+			{
+				statusCode: 201,
+				body:       []byte("second"),
+			},
+			{
+				statusCode: 202,
+				body:       []byte("third"),
+			},
+		},
+		shutdownServer: func() {
+			t.Error("shutdownServer should not be called")
+		},
+		indexHeader: "X-Mock-Index",
+	}
+
+	requests := []struct {
+		index      string
+		expectCode int
+		expectBody []byte
+	}{
+		{index: "2", expectCode: 202, expectBody: []byte("third")},
+		{index: "0", expectCode: 200, expectBody: []byte("first")},
+		{index: "2", expectCode: 202, expectBody: []byte("third")},
+		{index: "1", expectCode: 201, expectBody: []byte("second")},
+		{index: "3", expectCode: 400},
+		{index: "-1", expectCode: 400},
+		{index: "first", expectCode: 400},
+	}
+
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Mock-Index", req.index)
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != req.expectCode {
+			t.Errorf("index %s: code does not match: expect %d, got: %d", req.index, req.expectCode, w.Code)
+		}
+		if req.expectBody != nil && !bytes.Equal(w.Body.Bytes(), req.expectBody) {
+			t.Errorf("index %s: body does not match: expect %s, got: %s", req.index, req.expectBody, w.Body.Bytes())
+		}
+	}
+
+	if handler.pos != 0 {
+		t.Errorf("handler.pos is expected to be 0, but %d", handler.pos)
+	}
+}
+
+func TestHandler_ServeHTTPCrashOnRequest(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHandler_ServeHTTPCrashOnRequestHelper$")
+	cmd.Env = append(os.Environ(), "CRASH_ON_REQUEST_HELPER=1")
+	out, err := cmd.CombinedOutput()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("helper process is expected to exit with 1, but got: %v\n%s", err, out)
+	}
+	if !bytes.Contains(out, []byte("Crashing on request 2")) {
+		t.Errorf("crash message was not logged:\n%s", out)
+	}
+}
+
+// TestHandler_ServeHTTPCrashOnRequestHelper is run as a subprocess by TestHandler_ServeHTTPCrashOnRequest.
+func TestHandler_ServeHTTPCrashOnRequestHelper(t *testing.T) {
+	if os.Getenv("CRASH_ON_REQUEST_HELPER") != "1" {
+		t.Skip("helper process for TestHandler_ServeHTTPCrashOnRequest")
+	}
+
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("OK")},
+			{statusCode: 200, body: []byte("OK")},
+			{statusCode: 200, body: []byte("OK")},
+		},
+		shutdownServer: func() {},
+		crashOnRequest: 2,
+	}
+
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+}
+
+func TestHandler_ServeHTTPMethodBody(t *testing.T) {
+	cases := []struct {
+		method     string
+		expectBody []byte
+	}{
+		{method: "GET", expectBody: []byte("get body")},
+		{method: "POST", expectBody: []byte("post body")},
+		{method: "PUT", expectBody: []byte("default body")},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.method, func(t *testing.T) {
+			t.Parallel()
+
+			handler := &handler{
+				responses: []*response{
+					{
+						statusCode: 200,
+						body:       []byte("default body"),
+						methodBodies: map[string][]byte{
+							"GET":  []byte("get body"),
+							"POST": []byte("post body"),
+						},
+					},
+				},
+				shutdownServer: func() {},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(c.method, "/", nil)
+
+			handler.ServeHTTP(w, r)
+
+			if !bytes.Equal(w.Body.Bytes(), c.expectBody) {
+				t.Errorf("body does not match: expect %s, got: %s", c.expectBody, w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTPNotFoundTemplate(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("OK")},
+		},
+		shutdownServer:   func() {},
+		notFoundTemplate: template.Must(template.New("").Parse("no response for {{.Method}} {{.Path}}")),
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/users?id=1", nil)
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("code does not match: expect %d, got: %d", http.StatusNotFound, w.Code)
+	}
+	expectBody := "no response for POST /api/users"
+	if w.Body.String() != expectBody {
+		t.Errorf("body does not match: expect %s, got: %s", expectBody, w.Body.String())
+	}
+}
+
+func TestHandler_ServeHTTPRateLimit(t *testing.T) {
+	responses := make([]*response, 10)
+	for i := range responses {
+		responses[i] = &response{statusCode: 200, body: []byte("OK")}
+	}
+	handler := &handler{
+		responses:      responses,
+		shutdownServer: func() {},
+		rateLimiter:    newTokenBucket(&rateLimitConfig{count: 3, interval: time.Minute}),
+	}
+
+	codes := map[int]int{}
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		codes[w.Code]++
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Error("Retry-After header is missing")
+		}
+	}
+
+	expect := map[int]int{http.StatusOK: 3, http.StatusTooManyRequests: 7}
+	if !reflect.DeepEqual(codes, expect) {
+		t.Errorf("status codes: expect %v, but got %v", expect, codes)
+	}
+	if handler.pos != 3 {
+		t.Errorf("handler.pos is expected to be 3, but %d", handler.pos)
+	}
+}
+
+func TestHandler_ServeHTTPMethodOverride(t *testing.T) {
+	cases := []struct {
+		name       string
+		honor      bool
+		expectBody []byte
+		expectLog  string
+	}{
+		{name: "Honored", honor: true, expectBody: []byte("put body"), expectLog: "Method overridden: POST -> PUT\n"},
+		{name: "NotHonored", honor: false, expectBody: []byte("post body"), expectLog: ""},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			out := &bytes.Buffer{}
+			handler := &handler{
+				responses: []*response{
+					{
+						statusCode: 200,
+						methodBodies: map[string][]byte{
+							"POST": []byte("post body"),
+							"PUT":  []byte("put body"),
+						},
+					},
+				},
+				shutdownServer:      func() {},
+				logSummary:          true,
+				honorMethodOverride: c.honor,
+			}
+			handler.logger.out = out
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/", nil)
+			r.Header.Set("X-HTTP-Method-Override", "put")
+
+			handler.ServeHTTP(w, r)
+
+			if !bytes.Equal(w.Body.Bytes(), c.expectBody) {
+				t.Errorf("body does not match: expect %s, got: %s", c.expectBody, w.Body.Bytes())
+			}
+			expectLog := `POST / Host="example.com"` + "\n" + c.expectLog
+			if out.String() != expectLog {
+				t.Errorf("log does not match: expect %q, got: %q", expectLog, out.String())
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTPMatchCookie(t *testing.T) {
+	shutdown := make(chan struct{})
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("session"), matchCookie: &http.Cookie{Name: "session", Value: "abc"}},
+			{statusCode: 200, body: []byte("anonymous")},
+		},
+		shutdownServer:   func() { close(shutdown) },
+		notFoundTemplate: template.Must(template.New("").Parse("not found")),
+	}
+	handler.logger.out = io.Discard
+
+	steps := []struct {
+		cookie     string
+		expectCode int
+		expectBody string
+	}{
+		{cookie: "", expectCode: 200, expectBody: "anonymous"},
+		{cookie: "", expectCode: 404, expectBody: "not found"},
+		{cookie: "session=xyz", expectCode: 404, expectBody: "not found"},
+		{cookie: "session=abc", expectCode: 200, expectBody: "session"},
+	}
+
+	for i, s := range steps {
+		r := httptest.NewRequest("GET", "/", nil)
+		if s.cookie != "" {
+			r.Header.Set("Cookie", s.cookie)
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != s.expectCode || w.Body.String() != s.expectBody {
+			t.Errorf("step %d: expect %d %q, got: %d %q", i, s.expectCode, s.expectBody, w.Code, w.Body.String())
+		}
+	}
+
+	select {
+	case <-shutdown:
+	case <-time.After(time.Second):
+		t.Errorf("server was not shut down after all responses were served")
+	}
+}
+
+func TestHandler_ServeHTTPBodyTemplate(t *testing.T) {
+	sc, err := parseArgs([]string{
+		"--body-prefix", "[",
+		"200", "{{.Method}} {{.Path}} index={{.Index}} remaining={{.Remaining}}", "--template", "-r", "3",
+	})
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	handler := newHandler(sc, func() {})
+	handler.logger.out = io.Discard
+
+	for i, expect := range []string{
+		"[GET /a index=1 remaining=2",
+		"[GET /b index=2 remaining=1",
+		"[GET /c index=3 remaining=0",
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/"+string(rune('a'+i)), nil))
+
+		if w.Body.String() != expect {
+			t.Errorf("request %d: expect %q, got: %q", i, expect, w.Body.String())
+		}
+		if w.Header().Get("Content-Length") != strconv.Itoa(len(expect)) {
+			t.Errorf("request %d: Content-Length: expect %d, got: %s", i, len(expect), w.Header().Get("Content-Length"))
+		}
+	}
+}
+
+func TestHandler_ServeHTTPTemplateError(t *testing.T) {
+	cases := []struct {
+		name              string
+		templateErrorBody string
+		expectBody        string
+	}{
+		{name: "Default", templateErrorBody: "", expectBody: "Internal Server Error\n"},
+		{name: "Configured", templateErrorBody: "template failed", expectBody: "template failed\n"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			args := []string{"200", "{{.Missing}}", "--template"}
+			if c.templateErrorBody != "" {
+				args = append([]string{"--template-error-body", c.templateErrorBody}, args...)
+			}
+			sc, err := parseArgs(args)
+			if err != nil {
+				t.Fatalf("error was not expected but got: %#v", err)
+			}
+			errOut := &bytes.Buffer{}
+			handler := newHandler(sc, func() {})
+			handler.logger.out = io.Discard
+			handler.logger.errOut = errOut
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			if w.Code != 500 || w.Body.String() != c.expectBody {
+				t.Errorf("expect 500 %q, got: %d %q", c.expectBody, w.Code, w.Body.String())
+			}
+			if log := errOut.String(); !strings.Contains(log, "Failed to render body template on request 1") || !strings.Contains(log, "Missing") {
+				t.Errorf("the template error is not logged: %q", log)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTPNoBodyStatus(t *testing.T) {
+	cases := []struct {
+		name       string
+		rc         *responseConfig
+		prefix     string
+		expectWarn bool
+	}{
+		{name: "204WithBody", rc: &responseConfig{statusCode: 204, body: []byte("ignored")}, expectWarn: true},
+		{name: "304WithBody", rc: &responseConfig{statusCode: 304, body: []byte("ignored")}, expectWarn: true},
+		{name: "204", rc: &responseConfig{statusCode: 204}},
+		{name: "204Gzip", rc: &responseConfig{statusCode: 204, gzip: true}},
+		{name: "204Brotli", rc: &responseConfig{statusCode: 204, brotli: true}},
+		{name: "204GRPCWeb", rc: &responseConfig{statusCode: 204, grpcWeb: true}},
+		{name: "204BodyPrefix", rc: &responseConfig{statusCode: 204}, prefix: "prefix"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			errOut := &bytes.Buffer{}
+			sc := &serverConfig{headers: http.Header{}, bodyPrefix: []byte(c.prefix)}
+			handler := &handler{
+				responses:      []*response{newResponse(c.rc, sc)},
+				shutdownServer: func() {},
+			}
+			handler.logger.out = io.Discard
+			handler.logger.errOut = errOut
+
+			handler.warnIgnoredBodies()
+			if warned := strings.Contains(errOut.String(), "Ignoring the body"); warned != c.expectWarn {
+				t.Errorf("warning: expect %v, got: %q", c.expectWarn, errOut.String())
+			}
+			errOut.Reset()
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			if w.Code != c.rc.statusCode {
+				t.Errorf("status code: expect %d, got: %d", c.rc.statusCode, w.Code)
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("body must be empty, got: %q", w.Body.Bytes())
+			}
+			for _, name := range []string{"Content-Length", "Content-Encoding"} {
+				if v := w.Header().Get(name); v != "" {
+					t.Errorf("%s must not be set, got: %s", name, v)
+				}
+			}
+			if errOut.Len() != 0 {
+				t.Errorf("nothing is expected to be logged on requests, got: %q", errOut.String())
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTPLogSummary(t *testing.T) {
+	out := &bytes.Buffer{}
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("OK")},
+		},
+		shutdownServer: func() {},
+		logSummary:     true,
+	}
+	handler.logger.out = out
+
+	r := httptest.NewRequest("POST", "http://example.com/api/users?id=1", strings.NewReader(`{"secret":"body"}`))
+	r.Header.Set("User-Agent", "test-agent/1.0")
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	expect := `POST /api/users?id=1 Host="example.com" User-Agent="test-agent/1.0" Content-Type="application/json"` + "\n"
+	if out.String() != expect {
+		t.Errorf("log does not match: expect %q, got: %q", expect, out.String())
+	}
+}
+
+func TestHandler_ServeHTTPLogCurl(t *testing.T) {
+	out := &bytes.Buffer{}
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("OK")},
+		},
+		shutdownServer: func() {},
+		logSummary:     true,
+		logCurl:        true,
+	}
+	handler.logger.out = out
+
+	r := httptest.NewRequest("PUT", "http://example.com/items?id=1&q=it's", nil)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Add("X-Multi", "a")
+	r.Header.Add("X-Multi", "b")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	expect := `curl -X PUT 'http://example.com/items?id=1&q=it'\''s' -H 'Content-Type: application/json' -H 'X-Multi: a' -H 'X-Multi: b'`
+	if len(lines) != 2 || lines[1] != expect {
+		t.Errorf("curl command does not match: expect %q, got: %q", expect, out.String())
+	}
+}
+
+func TestHandler_ServeHTTPTrace(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("OK")},
+		},
+		shutdownServer: func() {},
+		enableTrace:    true,
+	}
+	handler.logger.out = io.Discard
+
+	r := httptest.NewRequest("TRACE", "/path?q=1", nil)
+	r.Header.Set("X-Test", "traced")
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != 200 || w.Header().Get("Content-Type") != "message/http" {
+		t.Errorf("expect 200 message/http, got: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	expect := "TRACE /path?q=1 HTTP/1.1\r\nHost: example.com\r\nX-Test: traced\r\n\r\n"
+	if w.Body.String() != expect {
+		t.Errorf("body does not match: expect %q, got: %q", expect, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Body.String() != "OK" {
+		t.Errorf("TRACE should not consume the sequence, but got: %q", w.Body.String())
+	}
+}
+
+func TestHandler_ServeHTTPStatusFromPath(t *testing.T) {
+	cases := []struct {
+		path       string
+		expectCode int
+		expectBody string
+	}{
+		{path: "/status/503", expectCode: 503, expectBody: "Service Unavailable\n"},
+		{path: "/status/201", expectCode: 201, expectBody: "Created\n"},
+		{path: "/status/204", expectCode: 204, expectBody: ""},
+		{path: "/status/99", expectCode: 400, expectBody: "invalid status in path: \"/status/99\"\n"},
+		{path: "/status/abc", expectCode: 400, expectBody: "invalid status in path: \"/status/abc\"\n"},
+		{path: "/status/503/more", expectCode: 200, expectBody: "sequence"},
+		{path: "/other/503", expectCode: 200, expectBody: "sequence"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.path, func(t *testing.T) {
+			t.Parallel()
+
+			sc, err := parseArgs([]string{"--status-from-path", "/status/", "-H", "X-Global: yes", "200", "sequence"})
+			if err != nil {
+				t.Fatalf("parseArgs failed: %s", err)
+			}
+			handler := newHandler(sc, func() {})
+			handler.logger.out = io.Discard
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+
+			if w.Code != c.expectCode || w.Body.String() != c.expectBody {
+				t.Errorf("expect %d %q, got: %d %q", c.expectCode, c.expectBody, w.Code, w.Body.String())
+			}
+			if w.Code != 400 && w.Header().Get("X-Global") != "yes" {
+				t.Errorf("global header is expected, but got: %v", w.Header())
+			}
+			handler.mu.Lock()
+			defer handler.mu.Unlock()
+			if consumed := handler.pos == 1; consumed != (c.expectBody == "sequence") {
+				t.Errorf("sequence is consumed only by non-matching paths, but next is %d", handler.pos)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTPStatusFromPathBodyPrefix(t *testing.T) {
+	sc, err := parseArgs([]string{"--status-from-path", "/status/", "--body-prefix", "[", "--body-suffix", "]", "200", "sequence"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %s", err)
+	}
+	handler := newHandler(sc, func() {})
+	handler.logger.out = io.Discard
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/status/503", nil))
+
+	if expect := "[Service Unavailable\n]"; w.Code != 503 || w.Body.String() != expect {
+		t.Errorf("expect 503 %q, got: %d %q", expect, w.Code, w.Body.String())
+	}
+}
+
+func TestHandler_ServeHTTPIgnoreFavicon(t *testing.T) {
+	out := &bytes.Buffer{}
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("OK")},
+		},
+		shutdownServer: func() {},
+		ignoreFavicon:  true,
+	}
+	handler.logger.out = out
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/favicon.ico", nil))
+
+	if w.Code != 204 || w.Body.Len() != 0 {
+		t.Errorf("expect 204 without body, got: %d %q", w.Code, w.Body.String())
+	}
+	if out.Len() != 0 {
+		t.Errorf("favicon request is expected not to be logged, but got: %q", out.String())
+	}
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if handler.pos != 0 || handler.requestCount != 0 {
+		t.Errorf("favicon request is expected not to touch the sequence, but next is %d after %d requests", handler.pos, handler.requestCount)
+	}
+}
+
+func TestHandler_ServeHTTPRequireBody(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("created"), requireBody: 422},
+			{statusCode: 200, body: []byte("next")},
+		},
+		shutdownServer: func() {},
+	}
+	handler.logger.out = io.Discard
+
+	chunked := httptest.NewRequest("POST", "/", strings.NewReader("chunked"))
+	chunked.ContentLength = -1
+	emptyChunked := httptest.NewRequest("POST", "/", strings.NewReader(""))
+	emptyChunked.ContentLength = -1
+
+	steps := []struct {
+		name       string
+		req        *http.Request
+		expectCode int
+		expectBody string
+	}{
+		{name: "NoBody", req: httptest.NewRequest("POST", "/", nil), expectCode: 422, expectBody: "request body is required\n"},
+		{name: "EmptyChunked", req: emptyChunked, expectCode: 422, expectBody: "request body is required\n"},
+		{name: "Body", req: httptest.NewRequest("POST", "/", strings.NewReader("data")), expectCode: 200, expectBody: "created"},
+		{name: "NotRequired", req: httptest.NewRequest("POST", "/", nil), expectCode: 200, expectBody: "next"},
+	}
+
+	for _, s := range steps {
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, s.req)
+
+		if w.Code != s.expectCode || w.Body.String() != s.expectBody {
+			t.Errorf("%s: expect %d %q, got: %d %q", s.name, s.expectCode, s.expectBody, w.Code, w.Body.String())
+		}
+	}
+
+	// the body read ahead is still served to the handler
+	if !hasBody(chunked) {
+		t.Fatal("chunked body is expected to be detected")
+	}
+	if b, _ := io.ReadAll(chunked.Body); string(b) != "chunked" {
+		t.Errorf("body read ahead is lost: %q", b)
+	}
+}
+
+func TestHandler_ServeHTTPReloadFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "body.txt")
+	if err := os.WriteFile(file, []byte("before"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := parseArgs([]string{"200", file, "--body-file", "--reload-file", "--repeat", "3"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %s", err)
+	}
+	handler := newHandler(c, func() {})
+	handler.logger.out = io.Discard
+
+	steps := []struct {
+		name       string
+		content    string
+		expectCode int
+		expectBody string
+	}{
+		{name: "Initial", content: "before", expectCode: 200, expectBody: "before"},
+		{name: "Changed", content: "after", expectCode: 200, expectBody: "after"},
+		{name: "Removed", expectCode: 500, expectBody: "Internal Server Error\n"},
+	}
+
+	for _, s := range steps {
+		if s.content != "" {
+			if err := os.WriteFile(file, []byte(s.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.Remove(file); err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		if w.Code != s.expectCode || w.Body.String() != s.expectBody {
+			t.Errorf("%s: expect %d %q, got: %d %q", s.name, s.expectCode, s.expectBody, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandler_ServeHTTPGzipBomb(t *testing.T) {
+	// with and without the remainder of the repeated block
+	for _, size := range []int{100, 3 << 20, 10<<20 + 12345} {
+		c, err := parseArgs([]string{"--body-prefix", "prefix", "200", "", "--gzip-bomb", strconv.Itoa(size)})
+		if err != nil {
+			t.Fatalf("parseArgs failed: %s", err)
+		}
+		handler := newHandler(c, func() {})
+		handler.logger.out = io.Discard
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		if ce := w.Header().Get("Content-Encoding"); ce != "gzip" {
+			t.Errorf("%d: Content-Encoding: expect gzip, got: %q", size, ce)
+		}
+		if size >= 1<<20 && w.Body.Len() > size/500 {
+			t.Errorf("%d: compressed body is expected to be small, but %d bytes", size, w.Body.Len())
+		}
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("%d: body is not gzip: %s", size, err)
+		}
+		// the reader also verifies the checksum and the size in the trailer
+		n, err := io.Copy(io.Discard, zr)
+		if err != nil {
+			t.Fatalf("%d: decompressing failed: %s", size, err)
+		}
+		if n != int64(size) {
+			t.Errorf("decompressed size: expect %d, got: %d", size, n)
+		}
+	}
+}
+
+func TestHandler_ServeHTTPCountHeader(t *testing.T) {
+	shutdownCh := make(chan struct{}, 1)
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("first")},
+			{statusCode: 201, body: []byte("second")},
+			{statusCode: 202, body: []byte("third")},
+		},
+		shutdownServer: func() { shutdownCh <- struct{}{} },
+		countHeader:    "X-Count",
+	}
+	handler.logger.out = io.Discard
+
+	steps := []struct {
+		name       string
+		counter    string
+		expectCode int
+		expectBody string
+	}{
+		{name: "First", counter: "1", expectCode: 200, expectBody: "first"},
+		{name: "Second", counter: "2", expectCode: 201, expectBody: "second"},
+		{name: "Skipped", counter: "4", expectCode: 409, expectBody: "expected X-Count: 3, got: \"4\"\n"},
+		{name: "Repeated", counter: "2", expectCode: 409, expectBody: "expected X-Count: 3, got: \"2\"\n"},
+		{name: "Missing", expectCode: 409, expectBody: "expected X-Count: 3, got: \"\"\n"},
+		{name: "Third", counter: "3", expectCode: 202, expectBody: "third"},
+	}
+
+	for _, s := range steps {
+		r := httptest.NewRequest("GET", "/", nil)
+		if s.counter != "" {
+			r.Header.Set("X-Count", s.counter)
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != s.expectCode || w.Body.String() != s.expectBody {
+			t.Errorf("%s: expect %d %q, got: %d %q", s.name, s.expectCode, s.expectBody, w.Code, w.Body.String())
+		}
+	}
+
+	select {
+	case <-shutdownCh:
+	case <-time.After(time.Second):
+		t.Fatal("shutdownServer was not called after the last counter")
+	}
+
+	// the sequence does not wrap around without --loop
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("request after the sequence is expected to be aborted, but recovered %v", err)
+			}
+		}()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Count", "4")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		t.Errorf("request after the sequence is served %d %q", w.Code, w.Body.String())
+	}()
+}
+
+func TestHandler_ServeHTTPBadDigest(t *testing.T) {
+	for _, algorithm := range []string{"md5", "sha256"} {
+		c, err := parseArgs([]string{"200", "hello", "--bad-digest", algorithm})
+		if err != nil {
+			t.Fatalf("parseArgs failed: %s", err)
+		}
+		handler := newHandler(c, func() {})
+		handler.logger.out = io.Discard
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		a := digestAlgorithms[algorithm]
+		actual := base64.StdEncoding.EncodeToString(digestSum(algorithm, []byte("hello")))
+		contentDigest := w.Header().Get("Content-Digest")
+		if !strings.HasPrefix(contentDigest, a.key+"=:") || !strings.HasSuffix(contentDigest, ":") {
+			t.Errorf("%s: malformed Content-Digest: %q", algorithm, contentDigest)
+		} else if contentDigest == a.key+"=:"+actual+":" {
+			t.Errorf("%s: Content-Digest is expected not to match the body, but got: %q", algorithm, contentDigest)
+		}
+		digest := w.Header().Get("Digest")
+		if !strings.HasPrefix(digest, a.legacy+"=") {
+			t.Errorf("%s: malformed Digest: %q", algorithm, digest)
+		} else if digest == a.legacy+"="+actual {
+			t.Errorf("%s: Digest is expected not to match the body, but got: %q", algorithm, digest)
+		}
+		if w.Body.String() != "hello" {
+			t.Errorf("%s: body is expected to be served as is, but got: %q", algorithm, w.Body.String())
+		}
+	}
+}
+
+func TestHandler_ServeHTTPActiveWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("first"), activeUntil: start.Add(10 * time.Second)},
+			{statusCode: 200, body: []byte("second"), activeFrom: start.Add(5 * time.Second), activeUntil: start.Add(20 * time.Second)},
+			{statusCode: 200, body: []byte("third"), activeFrom: start.Add(30 * time.Second)},
+			{statusCode: 200, body: []byte("fourth")},
+		},
+		shutdownServer: func() {},
+		now:            func() time.Time { return now },
+	}
+	handler.logger.out = io.Discard
+
+	steps := []struct {
+		name       string
+		at         time.Duration
+		expectBody string
+	}{
+		{name: "BeforeWindow", at: 0, expectBody: "first"},
+		{name: "SkipNotYetActive", at: 2 * time.Second, expectBody: "fourth"},
+		{name: "InWindow", at: 10 * time.Second, expectBody: "second"},
+		{name: "NoneActive", at: 25 * time.Second, expectBody: ""},
+		{name: "WindowStart", at: 30 * time.Second, expectBody: "third"},
+	}
+
+	for _, s := range steps {
+		now = start.Add(s.at)
+		w := httptest.NewRecorder()
+
+		func() {
+			// the connection is aborted when no response is active
+			defer func() {
+				recover()
+			}()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		}()
+
+		if w.Body.String() != s.expectBody {
+			t.Errorf("%s: expect %q, got: %q", s.name, s.expectBody, w.Body.String())
+		}
+	}
+}
+
+func TestHandler_ServeHTTPReflectHeaders(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("OK"), headers: http.Header{"X-Reflect-Static": {"response"}}},
+		},
+		shutdownServer: func() {},
+		reflectHeaders: "X-Reflect-",
+	}
+	handler.logger.out = io.Discard
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("User-Agent", "test")
+	r.Header.Add("Accept", "text/plain")
+	r.Header.Add("Accept", "application/json")
+	r.Header.Set("x-custom", "value")
+
+	handler.ServeHTTP(w, r)
+
+	expect := http.Header{
+		"X-Reflect-User-Agent": {"test"},
+		"X-Reflect-Accept":     {"text/plain", "application/json"},
+		"X-Reflect-X-Custom":   {"value"},
+		"X-Reflect-Static":     {"response"},
+	}
+	for name, values := range expect {
+		if got := w.Header().Values(name); !reflect.DeepEqual(got, values) {
+			t.Errorf("%s: expect %q, got %q", name, values, got)
+		}
+	}
+}
+
+func TestHandler_ServeHTTPHashSelect(t *testing.T) {
+	cases := []struct {
+		hashSelect string
+		setAttr    func(r *http.Request, v string)
+	}{
+		{hashSelect: "path", setAttr: func(r *http.Request, v string) { r.URL.Path = "/" + v }},
+		{hashSelect: "header:X-User", setAttr: func(r *http.Request, v string) { r.Header.Set("X-User", v) }},
+		{hashSelect: "remote-addr", setAttr: func(r *http.Request, v string) { r.RemoteAddr = v + ":" + strconv.Itoa(rand.Intn(60000)+1024) }},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.hashSelect, func(t *testing.T) {
+			t.Parallel()
+
+			handler := &handler{
+				responses: []*response{
+					{statusCode: 200, body: []byte("0")},
+					{statusCode: 200, body: []byte("1")},
+					{statusCode: 200, body: []byte("2")},
+				},
+				shutdownServer: func() { t.Error("server is expected not to shut down") },
+				hashSelect:     c.hashSelect,
+			}
+			handler.logger.out = io.Discard
+
+			serve := func(v string) string {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("GET", "/", nil)
+				c.setAttr(r, v)
+				handler.ServeHTTP(w, r)
+				return w.Body.String()
+			}
+
+			served := map[string]bool{}
+			for i := 0; i < 20; i++ {
+				v := "10.0.0." + strconv.Itoa(i)
+				first := serve(v)
+				for j := 0; j < 3; j++ {
+					if got := serve(v); got != first {
+						t.Fatalf("%q: expect the same response %q, but got %q", v, first, got)
+					}
+				}
+				served[first] = true
+			}
+			if len(served) < 2 {
+				t.Errorf("expect varied responses for different attributes, but got %v", served)
+			}
+			handler.mu.Lock()
+			defer handler.mu.Unlock()
+			if handler.pos != 0 {
+				t.Errorf("sequence is expected not to advance, but next is %d", handler.pos)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTPTimingLog(t *testing.T) {
+	out := &bytes.Buffer{}
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("OK"), delay: 100 * time.Millisecond},
+		},
+		shutdownServer: func() {},
+		timingLog:      true,
+		logSummary:     true,
+	}
+	handler.logger.out = out
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	var line string
+	for _, l := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(l, "Timing: ") {
+			line = l
+		}
+	}
+	phases := map[string]time.Duration{}
+	for _, field := range strings.Fields(strings.TrimPrefix(line, "Timing: ")) {
+		name, value, _ := strings.Cut(field, "=")
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			t.Fatalf("invalid duration of %s in %q: %s", name, line, err)
+		}
+		phases[name] = d
+	}
+	if len(phases) != 3 {
+		t.Fatalf("expect delay, write and total phases, got %q", line)
+	}
+	if phases["delay"] < 100*time.Millisecond {
+		t.Errorf("delay: expect at least 100ms, got %s", phases["delay"])
+	}
+	if phases["total"] < phases["delay"]+phases["write"] {
+		t.Errorf("total %s is shorter than the phases", phases["total"])
+	}
+}
+
+func TestHandler_ServeHTTPPreferAsync(t *testing.T) {
+	shutdown := make(chan struct{}, 1)
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 201, body: []byte("first"), headers: http.Header{}},
+			{statusCode: 200, body: []byte("second"), headers: http.Header{}},
+		},
+		shutdownServer: func() { shutdown <- struct{}{} },
+		preferAsync:    true,
+	}
+	handler.logger.out = io.Discard
+
+	serve := func(path string, prefer string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		if prefer != "" {
+			r.Header.Set("Prefer", prefer)
+		}
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/", "wait=10, respond-async")
+	if w.Code != 202 || w.Header().Get("Location") != "/_async/1" || w.Header().Get("Preference-Applied") != "respond-async" {
+		t.Fatalf("expect 202 to /_async/1, got: %d %v", w.Code, w.Header())
+	}
+	w = serve("/", "Respond-Async")
+	if w.Code != 202 || w.Header().Get("Location") != "/_async/2" {
+		t.Fatalf("expect 202 to /_async/2, got: %d %v", w.Code, w.Header())
+	}
+
+	if w = serve("/_async/3", ""); w.Code != 404 {
+		t.Errorf("unknown status URL: expect 404, got: %d", w.Code)
+	}
+	if w = serve("/_async/2", ""); w.Code != 200 || w.Body.String() != "second" {
+		t.Errorf("expect 200 %q, got: %d %q", "second", w.Code, w.Body.String())
+	}
+	select {
+	case <-shutdown:
+		t.Fatal("server should not shut down until the last result is fetched")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if w = serve("/_async/1", ""); w.Code != 201 || w.Body.String() != "first" {
+		t.Errorf("expect 201 %q, got: %d %q", "first", w.Code, w.Body.String())
+	}
+	if w = serve("/_async/1", ""); w.Code != 404 {
+		t.Errorf("fetched status URL: expect 404, got: %d", w.Code)
+	}
+	select {
+	case <-shutdown:
+	case <-time.After(time.Second):
+		t.Error("server should shut down after the last result is fetched")
+	}
+}
+
+func TestHandler_ServeHTTPDelay(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{
+				statusCode: 200,
+				body:       []byte("OK"),
+			},
+			{
+				statusCode: 200,
+				body:       []byte("OK"),
+				delay:      100 * time.Millisecond,
+			},
+		},
+		shutdownServer: func() {},
+	}
+
+	expectDelays := []time.Duration{0, 100 * time.Millisecond}
+
+	for i, expect := range expectDelays {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+
+		start := time.Now()
+		handler.ServeHTTP(w, r)
+		elapsed := time.Since(start)
+
+		if elapsed < expect {
+			t.Errorf("%d-th response took %s, expected at least %s", i, elapsed, expect)
+		}
+	}
+}
+
+func TestHandler_ServeHTTPDelayFromHeader(t *testing.T) {
+	cases := []struct {
+		name      string
+		value     string
+		min       time.Duration
+		expectLog string
+	}{
+		{name: "Valid", value: "100ms", min: 100 * time.Millisecond},
+		{name: "Missing", value: ""},
+		{name: "Invalid", value: "soon", expectLog: "Ignoring invalid delay in X-Mock-Delay: \"soon\"\n"},
+		{name: "Negative", value: "-1s", expectLog: "Ignoring invalid delay in X-Mock-Delay: \"-1s\"\n"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			errOut := &bytes.Buffer{}
+			handler := &handler{
+				responses: []*response{
+					{statusCode: 200, body: []byte("OK")},
+				},
+				shutdownServer:  func() {},
+				delayFromHeader: "X-Mock-Delay",
+			}
+			handler.logger.out = io.Discard
+			handler.logger.errOut = errOut
+			r := httptest.NewRequest("GET", "/", nil)
+			if c.value != "" {
+				r.Header.Set("X-Mock-Delay", c.value)
+			}
+
+			start := time.Now()
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			elapsed := time.Since(start)
+
+			if elapsed < c.min {
+				t.Errorf("response took %s, expected at least %s", elapsed, c.min)
+			}
+			if errOut.String() != c.expectLog {
+				t.Errorf("log does not match: expect %q, got: %q", c.expectLog, errOut.String())
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTPDelayRamp(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("OK")},
+			{statusCode: 200, body: []byte("OK")},
+			{statusCode: 200, body: []byte("OK"), delay: 10 * time.Millisecond},
+		},
+		shutdownServer: func() {},
+		delayRamp:      50 * time.Millisecond,
+	}
+
+	expectDelays := []time.Duration{0, 50 * time.Millisecond, 110 * time.Millisecond}
+
+	for i, expect := range expectDelays {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+
+		start := time.Now()
+		handler.ServeHTTP(w, r)
+		elapsed := time.Since(start)
+
+		if elapsed < expect {
+			t.Errorf("%d-th response took %s, expected at least %s", i, elapsed, expect)
+		}
+	}
+}
+
+func TestHandler_ServeHTTPDelayRampRejected(t *testing.T) {
+	handler := &handler{
+		responses: []*response{
+			{statusCode: 200, body: []byte("created"), requireBody: 422},
+			{statusCode: 200, body: []byte("OK")},
+		},
+		shutdownServer: func() {},
+		delayRamp:      time.Millisecond,
+	}
+	handler.logger.out = io.Discard
+
+	// the rejected request does not ramp up the delay of the next one
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+	if w.Code != 422 {
+		t.Fatalf("request without a body: expect 422, got: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("body")))
+	if w.Code != 200 || w.Body.String() != "created" {
+		t.Errorf("expect 200 %q, got: %d %q", "created", w.Code, w.Body.String())
+	}
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if handler.requestCount != 2 || handler.servedCount != 1 {
+		t.Errorf("expect 2 requests and 1 served, got: %d requests and %d served", handler.requestCount, handler.servedCount)
+	}
+}
+
+func TestServerBodyAffix(t *testing.T) {
+	h := newHandler(&serverConfig{
+		headers:    http.Header{},
+		bodyPrefix: []byte("<wrap>"),
+		bodySuffix: []byte("</wrap>"),
+		responses: []*responseConfig{
+			{
+				statusCode: 200,
+				body:       []byte("OK"),
+			},
+		},
+	}, func() {})
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body failed: %s", err)
+	}
+
+	expectBody := []byte("<wrap>OK</wrap>")
+	if !bytes.Equal(body, expectBody) {
+		t.Errorf("body does not match: expected: %s, actual: %s", expectBody, body)
+	}
+	if resp.ContentLength != int64(len(expectBody)) {
+		t.Errorf("content length does not match: expected: %d, actual: %d", len(expectBody), resp.ContentLength)
+	}
+}
+
+func TestServerServerHeader(t *testing.T) {
+	c, err := parseArgs([]string{"--server-header", "mock/1.0", "200", "OK", "200", "OK", "-H", "Server: override/2.0"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %s", err)
+	}
+	s := httptest.NewServer(newHandler(c, func() {}))
+	defer s.Close()
+
+	for _, expect := range []string{"mock/1.0", "override/2.0"} {
+		resp, err := http.Get(s.URL)
+		if err != nil {
+			t.Fatalf("http.Get failed: %s", err)
+		}
+		resp.Body.Close()
+		if actual := resp.Header.Values("Server"); !reflect.DeepEqual(actual, []string{expect}) {
+			t.Errorf("Server header: expect %s, but got %v", expect, actual)
+		}
+	}
+}
+
+func TestServerMergeHeaders(t *testing.T) {
+	cases := []struct {
+		name         string
+		mergeHeaders bool
+		expect       map[string][]string
+	}{
+		{
+			name:         "Merged",
+			mergeHeaders: true,
+			expect: map[string][]string{
+				"Multi-Header":  {"value1, value2"},
+				"Single-Header": {"value"},
+				"Set-Cookie":    {"a=1", "b=2"},
+			},
+		},
+		{
+			name:         "Separate",
+			mergeHeaders: false,
+			expect: map[string][]string{
+				"Multi-Header":  {"value1", "value2"},
+				"Single-Header": {"value"},
+				"Set-Cookie":    {"a=1", "b=2"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := newHandler(&serverConfig{
+				headers:      http.Header{},
+				mergeHeaders: c.mergeHeaders,
+				responses: []*responseConfig{
+					{
+						statusCode: 200,
+						body:       []byte("OK"),
+						headers: httpHeader(map[string][]string{
+							"multi-header":  {"value1", "value2"},
+							"single-header": {"value"},
+							"set-cookie":    {"a=1", "b=2"},
+						}),
+					},
+				},
+			}, func() {})
+			s := httptest.NewServer(h)
+			defer s.Close()
+
+			resp, err := http.Get(s.URL)
+			if err != nil {
+				t.Fatalf("http.Get failed: %s", err)
+			}
+			resp.Body.Close()
+
+			for k, v := range c.expect {
+				actual := resp.Header.Values(k)
+				if !reflect.DeepEqual(v, actual) {
+					t.Errorf("header %q does not match: extected: %v, actual: %v", k, v, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestNewResponseGzipLevel(t *testing.T) {
+	words := []string{"alpha", "beta", "gamma", "delta", "epsilon", "zeta", "eta", "theta"}
+	rnd := rand.New(rand.NewSource(1))
+	buf := &bytes.Buffer{}
+	for i := 0; i < 10000; i++ {
+		buf.WriteString(words[rnd.Intn(len(words))] + " ")
+	}
+	body := buf.Bytes()
+
+	compressed := map[int][]byte{}
+	for _, level := range []int{1, 9} {
+		r := newResponse(&responseConfig{
+			statusCode: 200,
+			body:       body,
+			gzip:       true,
+		}, &serverConfig{headers: http.Header{}, gzipLevel: level})
+
+		if r.headers.Get("Content-Encoding") != "gzip" {
+			t.Errorf("level %d: Content-Encoding is not gzip: %q", level, r.headers.Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(r.body))
+		if err != nil {
+			t.Fatalf("level %d: body is not gzip: %s", level, err)
+		}
+		decompressed, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("level %d: decompressing body failed: %s", level, err)
+		}
+		if !bytes.Equal(decompressed, body) {
+			t.Errorf("level %d: decompressed body does not match", level)
+		}
+		compressed[level] = r.body
+	}
+
+	if len(compressed[1]) == len(compressed[9]) {
+		t.Errorf("compressed sizes should differ by level but both are %d bytes", len(compressed[1]))
+	}
+}
+
+func TestServerPadHeaders(t *testing.T) {
+	const size = 64 << 10
+	sc, err := parseArgs([]string{"200", "OK", "--pad-headers", strconv.Itoa(size)})
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	s := httptest.NewServer(newHandler(sc, func() {}))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if l := len(resp.Header.Get("X-Pad")); l != size {
+		t.Errorf("X-Pad length: expect %d, but got %d", size, l)
+	}
+}
+
+func TestNewResponseLineEnding(t *testing.T) {
+	cases := []struct {
+		name   string
+		args   []string
+		expect []byte
+	}{
+		{name: "Keep", args: []string{"200", "a\nb\r\nc\n"}, expect: []byte("a\nb\r\nc\n")},
+		{name: "CRLF", args: []string{"200", "a\nb\r\nc\n", "--crlf"}, expect: []byte("a\r\nb\r\nc\r\n")},
+		{name: "LF", args: []string{"200", "a\nb\r\nc\n", "--lf"}, expect: []byte("a\nb\nc\n")},
+		{name: "CRLFWithTrimNewline", args: []string{"200", "\na\nb\n", "--crlf", "--trim-newline"}, expect: []byte("a\r\nb")},
+		{name: "CRLFWithAffix", args: []string{"--body-suffix", "\n", "200", "a\nb", "--crlf"}, expect: []byte("a\r\nb\r\n")},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			sc, err := parseArgs(c.args)
+			if err != nil {
+				t.Fatalf("error was not expected but got: %#v", err)
+			}
+			r := newResponse(sc.responses[0], sc)
+			if !bytes.Equal(r.body, c.expect) {
+				t.Errorf("body does not match: expect %q, got: %q", c.expect, r.body)
+			}
+		})
+	}
+}
+
+func TestServerRedirectPreservingMethod(t *testing.T) {
+	cases := []struct {
+		name         string
+		option       string
+		expectStatus int
+	}{
+		{name: "Permanent", option: "--redirect-permanent", expectStatus: 308},
+		{name: "Temporary", option: "--redirect-temporary", expectStatus: 307},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			sc, err := parseArgs([]string{
+				"200", "", c.option, "/next",
+				"200", "default", "--method-body", "POST:posted",
+			})
+			if err != nil {
+				t.Fatalf("error was not expected but got: %#v", err)
+			}
+			if sc.responses[0].statusCode != c.expectStatus {
+				t.Errorf("status: expect %d, but got %d", c.expectStatus, sc.responses[0].statusCode)
+			}
+			h := newHandler(sc, func() {})
+			h.logger.out = io.Discard
+			s := httptest.NewServer(h)
+			defer s.Close()
+
+			resp, err := http.Post(s.URL, "text/plain", strings.NewReader("payload"))
+			if err != nil {
+				t.Fatalf("http.Post failed: %s", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.Request.URL.Path != "/next" {
+				t.Errorf("redirect was not followed: %s", resp.Request.URL)
+			}
+			if resp.Request.Method != "POST" || string(body) != "posted" {
+				t.Errorf("method is expected to be preserved, but got %s %q", resp.Request.Method, body)
+			}
+		})
+	}
+}
+
+func TestServerGzipContentLength(t *testing.T) {
+	body := bytes.Repeat([]byte("compressible "), 1000)
+	h := newHandler(&serverConfig{
+		headers: http.Header{},
+		responses: []*responseConfig{
+			{
+				statusCode: 200,
+				body:       body,
+				gzip:       true,
+			},
+		},
+	}, func() {})
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	// disable compression so that the client does not decompress the body transparently
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Get(s.URL)
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	defer resp.Body.Close()
+	compressed, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body failed: %s", err)
+	}
+
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("Content-Encoding: expect gzip, but got %q", resp.Header.Get("Content-Encoding"))
+	}
+	if len(compressed) >= len(body) {
+		t.Errorf("body is not compressed: %d bytes", len(compressed))
+	}
+	if resp.ContentLength != int64(len(compressed)) {
+		t.Errorf("Content-Length: expect the compressed size %d, but got %d", len(compressed), resp.ContentLength)
+	}
+}
+
+func TestServerGzipMinSize(t *testing.T) {
+	cases := []struct {
+		name           string
+		body           []byte
+		expectEncoding string
+	}{
+		{name: "Small", body: []byte("small"), expectEncoding: ""},
+		{name: "Large", body: bytes.Repeat([]byte("compressible "), 100), expectEncoding: "gzip"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := newHandler(&serverConfig{
+				headers:     http.Header{},
+				gzipMinSize: 1024,
+				responses: []*responseConfig{
+					{statusCode: 200, body: c.body, gzip: true},
+				},
+			}, func() {})
+			h.logger.out = io.Discard
+			s := httptest.NewServer(h)
+			defer s.Close()
+
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			resp, err := client.Get(s.URL)
+			if err != nil {
+				t.Fatalf("http.Get failed: %s", err)
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("reading body failed: %s", err)
+			}
+
+			if resp.Header.Get("Content-Encoding") != c.expectEncoding {
+				t.Errorf("Content-Encoding: expect %q, but got %q", c.expectEncoding, resp.Header.Get("Content-Encoding"))
+			}
+			if c.expectEncoding == "gzip" {
+				zr, err := gzip.NewReader(bytes.NewReader(got))
+				if err != nil {
+					t.Fatalf("body is not gzip: %s", err)
+				}
+				if got, err = io.ReadAll(zr); err != nil {
+					t.Fatalf("decompressing body failed: %s", err)
+				}
+			}
+			if !bytes.Equal(got, c.body) {
+				t.Errorf("body: expect %d bytes, got %q", len(c.body), got)
+			}
+			if resp.ContentLength < 0 {
+				t.Error("Content-Length is not set")
+			}
+		})
+	}
+}
+
+func TestServerTrailerChecksum(t *testing.T) {
+	body := []byte("checksummed body")
+	md5Sum := md5.Sum(body)
+	sha256Sum := sha256.Sum256(body)
+	cases := []struct {
+		algorithm string
+		expect    string
+	}{
+		{algorithm: "md5", expect: "md5=:" + base64.StdEncoding.EncodeToString(md5Sum[:]) + ":"},
+		{algorithm: "sha256", expect: "sha-256=:" + base64.StdEncoding.EncodeToString(sha256Sum[:]) + ":"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.algorithm, func(t *testing.T) {
+			t.Parallel()
+
+			sc, err := parseArgs([]string{"200", string(body), "--trailer-checksum", c.algorithm})
+			if err != nil {
+				t.Fatalf("error was not expected but got: %#v", err)
+			}
+			h := newHandler(sc, func() {})
+			h.logger.out = io.Discard
+			s := httptest.NewServer(h)
+			defer s.Close()
+
+			resp, err := http.Get(s.URL)
+			if err != nil {
+				t.Fatalf("http.Get failed: %s", err)
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("reading body failed: %s", err)
+			}
+
+			if !slices.Equal(resp.TransferEncoding, []string{"chunked"}) {
+				t.Errorf("Transfer-Encoding: expect chunked, but got %q", resp.TransferEncoding)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("body: expect %q, but got %q", body, got)
+			}
+			if digest := resp.Trailer.Get("Content-Digest"); digest != c.expect {
+				t.Errorf("Content-Digest trailer: expect %q, but got %q", c.expect, digest)
+			}
+		})
+	}
+}
+
+func TestServerClientDisconnected(t *testing.T) {
+	errOut := &bytes.Buffer{}
+	h := &handler{
+		responses: []*response{
+			// large enough not to fit in the socket buffers
+			{statusCode: 200, body: bytes.Repeat([]byte("a"), 64<<20)},
+		},
+		shutdownServer: func() {},
+		logSummary:     true,
+	}
+	h.logger.out = io.Discard
+	h.logger.errOut = errOut
+	done := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if _, err := conn.Read(make([]byte, 1024)); err != nil {
+		t.Fatalf("reading the response failed: %s", err)
+	}
+	// reset the connection in the middle of the body
+	conn.(*net.TCPConn).SetLinger(0)
+	conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handler did not return after the client disconnected")
+	}
+	expect := "Client disconnected while writing the response\n"
+	if errOut.String() != expect {
+		t.Errorf("log does not match: expect %q, got: %q", expect, errOut.String())
+	}
+}
+
+func TestServerAlsoSend(t *testing.T) {
+	sc, err := parseArgs([]string{"201", "created", "-H", "X-Test: yes", "--also-send", "2"})
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	h := newHandler(sc, func() {})
+	h.logger.out = io.Discard
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	for i := 0; i < 3; i++ {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("reading response %d failed: %s", i, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("reading body %d failed: %s", i, err)
+		}
+		if resp.StatusCode != 201 || string(body) != "created" || resp.Header.Get("X-Test") != "yes" {
+			t.Errorf("response %d: expect 201 %q with X-Test, got: %d %q %v", i, "created", resp.StatusCode, body, resp.Header)
+		}
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("connection is expected to be closed after the extra responses, but got: %v", err)
+	}
+}
+
+func TestServerMaxRequestsPerConn(t *testing.T) {
+	c, err := parseArgs([]string{"--max-requests-per-conn", "2", "200", "OK", "--until-signal"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %s", err)
+	}
+	c.addr = "127.0.0.1:0"
+	endpoints, err := listenAll(c)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(c)
+	server.handler.logger.out = io.Discard
+	go server.serveAll(endpoints, c.tls)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", endpoints[0].Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	br := bufio.NewReader(conn)
+	for i, expectClose := range []bool{false, true} {
+		fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("reading response %d failed: %s", i, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.Close != expectClose {
+			t.Errorf("response %d: expect close %t, got: %t", i, expectClose, resp.Close)
+		}
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("connection is expected to be closed after the max requests, but got: %v", err)
+	}
+}
+
+func TestServerContinueDelay(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "Default", args: []string{"--continue-delay", "300ms", "200", "OK"}},
+		// nothing reads the body to log the request
+		{name: "LogSummary", args: []string{"--continue-delay", "300ms", "--log-summary", "200", "OK"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := parseArgs(tt.args)
+			if err != nil {
+				t.Fatalf("error was not expected but got: %#v", err)
+			}
+			h := newHandler(sc, func() {})
+			h.logger.out = io.Discard
+			s := httptest.NewServer(h)
+			defer s.Close()
+
+			conn, err := net.Dial("tcp", s.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("dial failed: %s", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			start := time.Now()
+			fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n")
+			br := bufio.NewReader(conn)
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading 100 Continue failed: %s", err)
+			}
+			if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+				t.Errorf("100 Continue is expected to be delayed, but took %s", elapsed)
+			}
+			if line != "HTTP/1.1 100 Continue\r\n" {
+				t.Fatalf("expect 100 Continue, got: %q", line)
+			}
+			if _, err := br.ReadString('\n'); err != nil {
+				t.Fatalf("reading the end of 100 Continue failed: %s", err)
+			}
+
+			// the client proceeds to send the body only now
+			fmt.Fprint(conn, "body")
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("reading the response failed: %s", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != 200 || string(body) != "OK" {
+				t.Errorf("expect 200 %q, got: %d %q", "OK", resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestServerObsFold(t *testing.T) {
+	sc, err := parseArgs([]string{"200", "folded", "-H", "X-Test: yes", "--obs-fold", "X-Folded: first second third", "--obs-fold", "X-Single: one"})
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	h := newHandler(sc, func() {})
+	h.logger.out = io.Discard
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("reading the response failed: %s", err)
+	}
+	for _, expect := range []string{
+		"HTTP/1.1 200 OK\r\n",
+		"X-Test: yes\r\n",
+		"X-Folded: first\r\n second\r\n third\r\n",
+		"X-Single:\r\n one\r\n",
+		"\r\n\r\nfolded",
+	} {
+		if !strings.Contains(string(raw), expect) {
+			t.Errorf("response does not contain %q:\n%s", expect, raw)
+		}
+	}
+}
+
+func TestServerCorruptLength(t *testing.T) {
+	h := newHandler(&serverConfig{
+		headers: http.Header{},
+		responses: []*responseConfig{
+			{
+				statusCode:    200,
+				body:          []byte("OK"),
+				corruptLength: 10,
+			},
+		},
+	}, func() {})
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	client := &http.Client{Timeout: 200 * time.Millisecond}
+	resp, err := client.Get(s.URL)
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength != 12 {
+		t.Errorf("content length: expect 12, but got %d", resp.ContentLength)
+	}
+	_, err = io.ReadAll(resp.Body)
+	if !os.IsTimeout(err) {
+		t.Errorf("reading body is expected to time out, but got: %v", err)
+	}
+}
+
+func TestServerAlways(t *testing.T) {
+	l := httptest.NewUnstartedServer(nil).Listener
+	server := newServer(&serverConfig{
+		addr:    ":0",
+		headers: http.Header{},
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("healthy"), alwaysPath: "/health"},
+			{statusCode: 500, body: []byte("error1")},
+			{statusCode: 503, body: []byte("error2")},
+			{statusCode: 200, body: []byte("ok")},
+		},
+	})
+	c := make(chan error)
+	go func() {
+		c <- server.Serve(l)
+	}()
+
+	requests := []struct {
+		path       string
+		expectCode int
+		expectBody string
+	}{
+		{path: "/health", expectCode: 200, expectBody: "healthy"},
+		{path: "/api", expectCode: 500, expectBody: "error1"},
+		{path: "/health", expectCode: 200, expectBody: "healthy"},
+		{path: "/health", expectCode: 200, expectBody: "healthy"},
+		{path: "/api", expectCode: 503, expectBody: "error2"},
+		{path: "/health", expectCode: 200, expectBody: "healthy"},
+		{path: "/api", expectCode: 200, expectBody: "ok"},
+	}
+	for _, r := range requests {
+		resp, err := http.Get("http://" + l.Addr().String() + r.path)
+		if err != nil {
+			t.Fatalf("http.Get failed: %s", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("reading body failed: %s", err)
+		}
+		if resp.StatusCode != r.expectCode || string(body) != r.expectBody {
+			t.Errorf("%s: expect %d %s, but got %d %s", r.path, r.expectCode, r.expectBody, resp.StatusCode, body)
+		}
+	}
+
+	select {
+	case <-c:
+	case <-time.After(time.Second):
+		t.Error("server is not closed after the sequence")
+	}
+}
+
+func TestHandler_ServeHTTPRoundRobinPath(t *testing.T) {
+	args := []string{
+		"200", "first", "--always", "/a",
+		"200", "second", "--always", "/a",
+		"200", "third", "--always", "/a",
+		"200", "only", "--always", "/b",
+		"200", "sequence",
+	}
+	cases := []struct {
+		name   string
+		args   []string
+		paths  []string
+		expect []string
+	}{
+		{
+			name:   "RoundRobin",
+			args:   append([]string{"--round-robin-path"}, args...),
+			paths:  []string{"/a", "/a", "/b", "/a", "/a", "/b", "/a"},
+			expect: []string{"first", "second", "only", "third", "first", "only", "second"},
+		},
+		{
+			name:   "LastWins",
+			args:   args,
+			paths:  []string{"/a", "/a", "/b"},
+			expect: []string{"third", "third", "only"},
+		},
+	}
+
+	for _, c := range cases {
+		sc, err := parseArgs(c.args)
+		if err != nil {
+			t.Fatalf("%s: parseArgs failed: %s", c.name, err)
+		}
+		handler := newHandler(sc, func() {})
+		handler.logger.out = io.Discard
+
+		for i, path := range c.paths {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			if w.Body.String() != c.expect[i] {
+				t.Errorf("%s: request %d to %s: expect %q, got: %q", c.name, i, path, c.expect[i], w.Body.String())
+			}
+		}
+		if handler.pos != 0 {
+			t.Errorf("%s: the sequence is expected not to advance, but pos is %d", c.name, handler.pos)
+		}
+	}
+}
+
+func TestServerRequestReadTimeout(t *testing.T) {
+	h := newHandler(&serverConfig{
+		headers:            http.Header{},
+		requestReadTimeout: 100 * time.Millisecond,
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("first")},
+			{statusCode: 200, body: []byte("second")},
+		},
+	}, func() {})
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("slow "))
+		time.Sleep(300 * time.Millisecond)
+		pw.Write([]byte("body"))
+		pw.Close()
+	}()
+	resp, err := http.Post(s.URL, "text/plain", pr)
+	if err != nil {
+		t.Fatalf("http.Post failed: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("code does not match: expect %d, got: %d", http.StatusRequestTimeout, resp.StatusCode)
+	}
+
+	resp, err = http.Post(s.URL, "text/plain", strings.NewReader("fast body"))
+	if err != nil {
+		t.Fatalf("http.Post failed: %s", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("reading body failed: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "first" {
+		t.Errorf("timed out request should not consume the sequence, but got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestServerShutdownWebhook(t *testing.T) {
+	notifications := make(chan shutdownNotification, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n shutdownNotification
+		if r.Method != "POST" {
+			t.Errorf("webhook method: expect POST, but got %s", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("webhook payload is not JSON: %s", err)
+		}
+		notifications <- n
+	}))
+	defer receiver.Close()
+
+	l := httptest.NewUnstartedServer(nil).Listener
+	server := newServer(&serverConfig{
+		addr:            ":0",
+		headers:         http.Header{},
+		shutdownWebhook: receiver.URL,
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("OK")},
+			{statusCode: 200, body: []byte("OK")},
+		},
+	})
+	go server.Serve(l)
+
+	// Without keep-alive, no spare connection dialed by the transport is left
+	// for Shutdown to wait for.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("http://" + l.Addr().String())
+		if err != nil {
+			t.Fatalf("http.Get failed: %s", err)
+		}
+		resp.Body.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.waitForShutDown()
+		close(done)
+	}()
+
+	select {
+	case n := <-notifications:
+		expect := shutdownNotification{TotalRequests: 2, Reason: "sequence complete"}
+		if n != expect {
+			t.Errorf("notification: expect %#v, but got %#v", expect, n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not notified")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server is not closed")
+	}
+}
+
+func TestServerIdleShutdown(t *testing.T) {
+	sc := &serverConfig{
+		addr:         "127.0.0.1:0",
+		headers:      http.Header{},
+		idleShutdown: 500 * time.Millisecond,
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("OK")},
+			{statusCode: 200, body: []byte("OK")},
+		},
+	}
+	endpoints, err := listenAll(sc)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(sc)
+	server.handler.logger.out = io.Discard
+	start := time.Now()
+	go server.serveAll(endpoints, sc.tls)
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		server.waitForShutDown()
+		close(done)
+	}()
+
+	// a request restarts the idle period
+	time.Sleep(250 * time.Millisecond)
+	resp, err := http.Get("http://" + endpoints[0].Addr().String())
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	resp.Body.Close()
+	requested := time.Now()
+
+	select {
+	case <-done:
+		t.Fatalf("server is shut down %s after the request", time.Since(requested))
+	case <-time.After(time.Until(start.Add(600 * time.Millisecond))):
+	}
+
+	select {
+	case <-done:
+		if elapsed := time.Since(requested); elapsed < 500*time.Millisecond {
+			t.Errorf("server is expected to be shut down after the idle period, but took %s", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server is not shut down after the idle period")
+	}
+	if server.shutdownReason != "idle" {
+		t.Errorf("reason: expect idle, but got %q", server.shutdownReason)
+	}
+}
+
+func TestServerMaxRuntime(t *testing.T) {
+	sc := &serverConfig{
+		addr:       "127.0.0.1:0",
+		headers:    http.Header{},
+		maxRuntime: 300 * time.Millisecond,
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("OK"), untilSignal: true},
+		},
+	}
+	endpoints, err := listenAll(sc)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(sc)
+	server.handler.logger.out = io.Discard
+	start := time.Now()
+	go server.serveAll(endpoints, sc.tls)
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		server.waitForShutDown()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+			t.Errorf("server is expected to be shut down after the max runtime, but took %s", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server is not shut down after the max runtime")
+	}
+	if server.shutdownReason != "max runtime" {
+		t.Errorf("reason: expect max runtime, but got %q", server.shutdownReason)
+	}
+}
+
+func TestServerMaxRuntimeHangingResponse(t *testing.T) {
+	sc := &serverConfig{
+		addr:           "127.0.0.1:0",
+		headers:        http.Header{},
+		maxRuntime:     300 * time.Millisecond,
+		responseDelays: []time.Duration{time.Minute},
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("OK")},
+		},
+	}
+	endpoints, err := listenAll(sc)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(sc)
+	server.handler.logger.out = io.Discard
+	go server.serveAll(endpoints, sc.tls)
+	defer server.Close()
+
+	// the response is held across the max runtime
+	go func() {
+		resp, err := http.Get("http://" + endpoints[0].Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		server.waitForShutDown()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(300*time.Millisecond + maxRuntimeGrace + 5*time.Second):
+		t.Fatal("server is not shut down while a response is in progress")
+	}
+}
+
+func TestServerHTTPAndHTTPS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	c := &serverConfig{
+		addr:      "127.0.0.1:0",
+		httpsAddr: "127.0.0.1:0",
+		headers:   http.Header{},
+		tls: &tlsConfig{
+			certFile: certFile,
+			keyFile:  keyFile,
+		},
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("first")},
+			{statusCode: 200, body: []byte("second")},
+			{statusCode: 200, body: []byte("third")},
+		},
+	}
+	endpoints, err := listenAll(c)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	if len(endpoints) != 2 {
+		t.Fatalf("2 endpoints are expected but got %d", len(endpoints))
+	}
+
+	server := newServer(c)
+	errCh := make(chan error)
+	go func() {
+		errCh <- server.serveAll(endpoints, c.tls)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	httpURL := "http://" + endpoints[0].Addr().String()
+	httpsURL := "https://" + endpoints[1].Addr().String()
+
+	requests := []struct {
+		url        string
+		expectBody string
+	}{
+		{url: httpURL, expectBody: "first"},
+		{url: httpsURL, expectBody: "second"},
+		{url: httpURL, expectBody: "third"},
+	}
+	for _, r := range requests {
+		resp, err := client.Get(r.url)
+		if err != nil {
+			t.Fatalf("http.Get %s failed: %s", r.url, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("reading body failed: %s", err)
+		}
+		if string(body) != r.expectBody {
+			t.Errorf("body from %s does not match: expected: %s, actual: %s", r.url, r.expectBody, body)
+		}
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("http.ErrServerClosed was expected but got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("server is not closed")
+	}
+	server.waitForShutDown()
+}
+
+func TestServerRequireTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	sc := &serverConfig{
+		addr:      "127.0.0.1:0",
+		httpsAddr: "127.0.0.1:0",
+		headers:   http.Header{},
+		tls:       &tlsConfig{certFile: certFile, keyFile: keyFile},
+		responses: []*responseConfig{
+			{statusCode: 200, body: []byte("secure"), requireTLS: true},
+			{statusCode: 200, body: []byte("plain")},
+		},
+	}
+	endpoints, err := listenAll(sc)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(sc)
+	server.handler.logger.out = io.Discard
+	go server.serveAll(endpoints, sc.tls)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	plainURL := "http://" + endpoints[0].Addr().String()
+	tlsURL := "https://" + endpoints[1].Addr().String()
+
+	steps := []struct {
+		url        string
+		expectCode int
+		expectBody string
+	}{
+		// the plaintext request falls through to the next response
+		{url: plainURL, expectCode: 200, expectBody: "plain"},
+		{url: plainURL, expectCode: 426, expectBody: "Upgrade Required\n"},
+		{url: tlsURL, expectCode: 200, expectBody: "secure"},
+	}
+
+	for i, s := range steps {
+		resp, err := client.Get(s.url)
+		if err != nil {
+			t.Fatalf("step %d: http.Get failed: %s", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != s.expectCode || string(body) != s.expectBody {
+			t.Errorf("step %d: expect %d %q, got: %d %q", i, s.expectCode, s.expectBody, resp.StatusCode, body)
+		}
+		if resp.StatusCode == 426 && resp.Header.Get("Upgrade") == "" {
+			t.Errorf("step %d: Upgrade header is missing", i)
+		}
+	}
+}
+
+func TestServerEchoClientCert(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	clientCertFile, clientKeyFile := writeTestCert(t)
+	sc, err := parseArgs([]string{
+		"--cert", certFile, "--key", keyFile,
+		"--client-ca", clientCertFile, "--echo-client-cert",
+		"200", "OK", "-r", "2",
+	})
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	sc.addr = "127.0.0.1:0"
+	endpoints, err := listenAll(sc)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(sc)
+	server.handler.logger.out = io.Discard
+	go server.serveAll(endpoints, sc.tls)
+	defer server.Close()
+
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatalf("loading client certificate failed: %s", err)
+	}
+	cases := []struct {
+		name          string
+		certs         []tls.Certificate
+		expectSubject string
+	}{
+		{name: "WithoutCert", certs: nil, expectSubject: ""},
+		{name: "WithCert", certs: []tls.Certificate{clientCert}, expectSubject: "CN=mock-server test"},
+	}
+
+	for _, c := range cases {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: c.certs},
+			},
+		}
+		resp, err := client.Get("https://" + endpoints[0].Addr().String())
+		if err != nil {
+			t.Fatalf("%s: http.Get failed: %s", c.name, err)
+		}
+		resp.Body.Close()
+
+		if subject := resp.Header.Get("X-Client-Cert-Subject"); subject != c.expectSubject {
+			t.Errorf("%s: subject: expect %q, but got %q", c.name, c.expectSubject, subject)
+		}
+		if c.expectSubject != "" && resp.Header.Get("X-Client-Cert-Serial") != "1" {
+			t.Errorf("%s: serial: expect 1, but got %q", c.name, resp.Header.Get("X-Client-Cert-Serial"))
+		}
+	}
+}
+
+func TestServerALPN(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	cases := []struct {
+		name             string
+		alpn             []string
+		expectProto      string
+		expectNegotiated string
+	}{
+		{
+			name:             "HTTP1Only",
+			alpn:             []string{"http/1.1"},
+			expectProto:      "HTTP/1.1",
+			expectNegotiated: "http/1.1",
+		},
+		{
+			name:             "H2",
+			alpn:             []string{"h2", "http/1.1"},
+			expectProto:      "HTTP/2.0",
+			expectNegotiated: "h2",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			sc := &serverConfig{
+				addr:    "127.0.0.1:0",
+				headers: http.Header{},
+				tls: &tlsConfig{
+					certFile: certFile,
+					keyFile:  keyFile,
+					alpn:     c.alpn,
+				},
+				responses: []*responseConfig{
+					{statusCode: 200, body: []byte("OK")},
+				},
+			}
+			endpoints, err := listenAll(sc)
+			if err != nil {
+				t.Fatalf("listenAll failed: %s", err)
+			}
+			server := newServer(sc)
+			go server.serveAll(endpoints, sc.tls)
+			defer server.Close()
+
+			client := &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+					ForceAttemptHTTP2: true,
+				},
+			}
+			resp, err := client.Get("https://" + endpoints[0].Addr().String())
+			if err != nil {
+				t.Fatalf("http.Get failed: %s", err)
+			}
+			resp.Body.Close()
+
+			if resp.Proto != c.expectProto {
+				t.Errorf("protocol: expect %s, but got %s", c.expectProto, resp.Proto)
+			}
+			if resp.TLS.NegotiatedProtocol != c.expectNegotiated {
+				t.Errorf("negotiated protocol: expect %q, but got %q", c.expectNegotiated, resp.TLS.NegotiatedProtocol)
+			}
+		})
+	}
+}
+
+func TestServerHTTP2ConcurrentStreams(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	const streams = 50
+	responses := []*responseConfig{}
+	for i := 0; i <= streams; i++ {
+		responses = append(responses, &responseConfig{statusCode: 200, body: []byte(strconv.Itoa(i))})
+	}
+	sc := &serverConfig{
+		addr:      "127.0.0.1:0",
+		headers:   http.Header{},
+		tls:       &tlsConfig{certFile: certFile, keyFile: keyFile},
+		responses: responses,
+	}
+	endpoints, err := listenAll(sc)
+	if err != nil {
+		t.Fatalf("listenAll failed: %s", err)
+	}
+	server := newServer(sc)
+	server.handler.logger.out = io.Discard
+	var mu sync.Mutex
+	conns := 0
+	server.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	go server.serveAll(endpoints, sc.tls)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+	}
+	url := "https://" + endpoints[0].Addr().String()
+	get := func() (string, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.ProtoMajor != 2 {
+			return "", fmt.Errorf("expect HTTP/2, but got %s", resp.Proto)
+		}
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	// establish the connection first so that all the streams are multiplexed on it
+	if body, err := get(); err != nil || body != "0" {
+		t.Fatalf("expect %q, got %q, %v", "0", body, err)
+	}
+
+	bodies := make([]string, streams)
+	errs := make([]error, streams)
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i], errs[i] = get()
+		}(i)
+	}
+	wg.Wait()
+
+	served := map[string]bool{}
+	for i, body := range bodies {
+		if errs[i] != nil {
+			t.Fatalf("stream %d failed: %s", i, errs[i])
+		}
+		if served[body] {
+			t.Errorf("response %s is served more than once", body)
+		}
+		served[body] = true
+	}
+	for i := 1; i <= streams; i++ {
+		if !served[strconv.Itoa(i)] {
+			t.Errorf("response %d is skipped", i)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 {
+		t.Errorf("expect all streams on 1 connection, but got %d", conns)
+	}
+}
+
 func TestServer(t *testing.T) {
 	l := httptest.NewUnstartedServer(nil).Listener
 
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+const sseContentType = "text/event-stream"
+
+// sseKeepaliveComment is the comment written between events to keep the stream alive.
+// Clients ignore comments, and the blank line ends the frame without dispatching an event.
+var sseKeepaliveComment = []byte(":\n\n")
+
+// streamSSE writes the non-empty lines of the body as the data of server-sent events one by one,
+// flushing each of them and waiting interval between them. If keepalive is not zero,
+// a comment is written every keepalive while waiting.
+func (h *handler) streamSSE(w http.ResponseWriter, r *http.Request, body []byte, interval, keepalive time.Duration) {
+	rc := http.NewResponseController(w)
+	for i, line := range ndjsonLines(body) {
+		if i > 0 && interval > 0 && !h.waitSSE(w, r, interval, keepalive) {
+			return
+		}
+		event := make([]byte, 0, len("data: ")+len(line)+2)
+		event = append(append(append(event, "data: "...), line...), "\n\n"...)
+		if _, err := w.Write(event); err != nil {
+			h.logWriteError(err)
+			return
+		}
+		rc.Flush()
+	}
+}
+
+// waitSSE waits the duration writing keepalive comments and reports whether the stream goes on.
+func (h *handler) waitSSE(w http.ResponseWriter, r *http.Request, d, keepalive time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	var tick <-chan time.Time
+	if keepalive > 0 {
+		ticker := time.NewTicker(keepalive)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case <-tick:
+			if _, err := w.Write(sseKeepaliveComment); err != nil {
+				h.logWriteError(err)
+				return false
+			}
+			http.NewResponseController(w).Flush()
+		case <-r.Context().Done():
+			return false
+		}
+	}
+}
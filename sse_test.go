@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerSSE(t *testing.T) {
+	sc, err := parseArgs([]string{
+		"200", "first\nsecond\n\nthird", "--sse", "--sse-interval", "250ms", "--sse-keepalive", "100ms",
+	})
+	if err != nil {
+		t.Fatalf("error was not expected but got: %#v", err)
+	}
+	h := newHandler(sc, func() {})
+	h.logger.out = io.Discard
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type: expect text/event-stream, but got %q", ct)
+	}
+	if resp.ContentLength != -1 {
+		t.Errorf("Content-Length is expected to be unknown, but got %d", resp.ContentLength)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the stream failed: %s", err)
+	}
+	frames := strings.Split(strings.TrimSuffix(string(b), "\n\n"), "\n\n")
+
+	// the events are in order with keepalive comments between each of them
+	data, comments := []string{}, 0
+	for i, frame := range frames {
+		switch {
+		case frame == ":":
+			if len(data) == 0 {
+				t.Errorf("frame %d: comment is not expected before the first event", i)
+			}
+			comments++
+		case strings.HasPrefix(frame, "data: "):
+			if len(data) > 0 && comments == 0 {
+				t.Errorf("frame %d: comment is expected before %q", i, frame)
+			}
+			data = append(data, strings.TrimPrefix(frame, "data: "))
+			comments = 0
+		default:
+			t.Errorf("frame %d: unexpected frame %q", i, frame)
+		}
+	}
+	if strings.Join(data, ",") != "first,second,third" {
+		t.Errorf("events: expect first,second,third, but got %q", data)
+	}
+	if comments != 0 {
+		t.Errorf("comment is not expected after the last event, but got %d", comments)
+	}
+}
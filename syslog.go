@@ -0,0 +1,20 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+)
+
+const syslogTag = "mock-server"
+
+// openSyslog opens a writer to the syslog server at addr over network,
+// or to the local syslog server if addr is empty.
+func openSyslog(c *syslogConfig) (io.Writer, error) {
+	priority := syslog.LOG_INFO | syslog.LOG_DAEMON
+	if c.addr == "" {
+		return syslog.New(priority, syslogTag)
+	}
+	return syslog.Dial(c.network, c.addr, priority, syslogTag)
+}
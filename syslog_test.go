@@ -0,0 +1,36 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket failed: %s", err)
+	}
+	defer conn.Close()
+
+	w, err := openSyslog(&syslogConfig{network: "udp", addr: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("openSyslog failed: %s", err)
+	}
+	l := &logger{out: w}
+	l.log(l.stdout(), "GET /health HTTP/1.1")
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no log arrived: %s", err)
+	}
+	msg := string(buf[:n])
+	if !strings.Contains(msg, "GET /health HTTP/1.1") || !strings.Contains(msg, syslogTag) {
+		t.Errorf("unexpected syslog message: %q", msg)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"text/template"
+)
+
+// requestData is the data templates are rendered against.
+type requestData struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	// Index is the ordinal of the request, starting from 1.
+	Index int
+	// Remaining is the number of responses left in the sequence after the response.
+	Remaining int
+}
+
+func newRequestData(r *http.Request) *requestData {
+	return &requestData{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header,
+	}
+}
+
+func renderTemplate(t *template.Template, data any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
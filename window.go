@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// activeTime is a bound of the time window a response is served in:
+// either an offset from the startup or an absolute time.
+type activeTime struct {
+	offset time.Duration
+	at     time.Time
+}
+
+// parseActiveTime parses a duration from the startup like 30s or an RFC 3339 time.
+func parseActiveTime(s string) (*activeTime, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return nil, fmt.Errorf("offset must not be negative: %q", s)
+		}
+		return &activeTime{offset: d}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("must be a duration or an RFC 3339 time: %q", s)
+	}
+	return &activeTime{at: t}, nil
+}
+
+// resolve returns the time of the bound for the startup at start, or the zero time if t is nil.
+func (t *activeTime) resolve(start time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	if !t.at.IsZero() {
+		return t.at
+	}
+	return start.Add(t.offset)
+}
+
+// active reports whether the response is served at now.
+func (r *response) active(now time.Time) bool {
+	if !r.activeFrom.IsZero() && now.Before(r.activeFrom) {
+		return false
+	}
+	return r.activeUntil.IsZero() || now.Before(r.activeUntil)
+}
+
+// clock returns the current time.
+func (h *handler) clock() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}